@@ -7,21 +7,25 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/archive"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/bindings"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/buildpacks"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/credhub"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/databaseuri"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/failure"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/keychain"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/project"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/serviceenv"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/transport"
 	"github.com/spf13/cobra"
 
 	"github.com/buildpacks/pack/pkg/blob"
-	"github.com/buildpacks/pack/pkg/image"
 
 	"github.com/buildpacks/lifecycle/api"
 	"github.com/buildpacks/lifecycle/buildpack"
@@ -35,9 +39,12 @@ import (
 )
 
 const (
-	PlatformAPI          = "0.14"
+	DefaultPlatformAPI   = "0.14"
 	DefaultLayersPath    = "/home/vcap/layers"
 	DefaultWorkspacePath = "/home/vcap/workspace"
+
+	// Deprecated: PlatformAPI is the default of --platform-api, use DefaultPlatformAPI.
+	PlatformAPI = DefaultPlatformAPI
 )
 
 var (
@@ -55,15 +62,34 @@ var (
 	systemBuildpacksDir       string
 	extensionsDir             string
 	downloadCacheDir          string
+	inlineBuildpacksDir       string
 	credhubConnectionAttempts int
 	credhubRetryDelay         time.Duration
+	credhubDeadline           time.Duration
+	credhubInterpolateEnv     []string
+	credhubInterpolateFiles   bool
+	targetFlag                string
+	platformAPIVersion        string
+	execEnv                   string
+	registrySources           keychain.Sources
+	transportOptions          transport.Options
+	logFormat                 string
+	failureReport             string
+	failureReportLines        int
 )
 
 func Execute() error {
-	return builderCmd.Execute()
+	err := builderCmd.Execute()
+	if err != nil && builderCmd.SilenceErrors {
+		cmd.DefaultLogger.Error(err.Error())
+	}
+
+	return err
 }
 
 func init() {
+	// returned errors are printed with their cause
+	builderCmd.SetErr(log.NewRedactingWriter(os.Stderr))
 	builderCmd.Flags().StringSliceVarP(&buildpackList, "buildpack", "b", nil, "buildpack(s) to use")
 	builderCmd.Flags().StringVarP(&systemBuildpacksDir, "system-buildpacks-dir", "", "/tmp/buildpacks", "system buildpacks dir")
 	builderCmd.Flags().StringVarP(&dropletFile, "droplet", "d", "/tmp/droplet", "output droplet file")
@@ -76,39 +102,84 @@ func init() {
 	builderCmd.Flags().BoolVar(&autoDetect, "auto-detect", false, "run auto-detection with the provided buildpacks")
 	builderCmd.Flags().IntVar(&credhubConnectionAttempts, "credhub-connection-attempts", 3, "number of times that the credhub client will attempt to connect to credhub")
 	builderCmd.Flags().DurationVar(&credhubRetryDelay, "credhub-retry-delay", 1*time.Second, "delay duration that credhub client will wait before retries (ex. 1s, 2m, etc.)")
+	builderCmd.Flags().DurationVar(&credhubDeadline, "credhub-deadline", 1*time.Minute, "total duration after which the credhub client gives up, 0 for no limit")
+	builderCmd.Flags().StringSliceVar(&credhubInterpolateEnv, "credhub-interpolate-env", nil, "environment variable(s) to interpolate credhub references in")
+	builderCmd.Flags().BoolVar(&credhubInterpolateFiles, "credhub-interpolate-env-files", false, "interpolate credhub references in the env files passed to buildpacks")
+	builderCmd.Flags().StringVar(&targetFlag, "target", "", "target the droplet is built for in the format os/arch[/variant][:distro@version], detected from the stack if empty")
+	builderCmd.Flags().StringVar(&platformAPIVersion, "platform-api", cmd.EnvOrDefault(cmd.EnvPlatformAPI, DefaultPlatformAPI), "platform API version used for the build and recorded for the launcher")
+	builderCmd.Flags().StringVar(&execEnv, "exec-env", cmd.EnvOrDefault(platform.EnvExecEnv, platform.DefaultExecEnv), "execution environment passed to buildpacks (ex. production, test, development), buildpacks and processes are only selected by it from platform API "+staging.ExecEnvPlatformAPI)
+	builderCmd.Flags().StringVar(&registrySources.DockerConfig, "registry-docker-config", os.Getenv(keychain.DockerConfigEnv), "docker config.json with registry credentials")
+	builderCmd.Flags().StringVar(&registrySources.SecretsDir, "registry-secrets-dir", os.Getenv(keychain.SecretsDirEnv), "directory of mounted dockerconfigjson secrets with registry credentials")
+	builderCmd.Flags().StringVar(&registrySources.CredentialsDir, "registry-creds-dir", os.Getenv(keychain.CredentialsDirEnv), "directory with a credentials file per registry")
+	builderCmd.Flags().StringVar(&transportOptions.CACerts, "ca-certs", os.Getenv("CF_SYSTEM_CERT_PATH"), "CA bundle or directory of CA certs to trust for downloads in addition to the system certs")
+	builderCmd.Flags().StringVar(&transportOptions.HTTPProxy, "http-proxy", "", "proxy for HTTP downloads")
+	builderCmd.Flags().StringVar(&transportOptions.HTTPSProxy, "https-proxy", "", "proxy for HTTPS downloads and registries")
+	builderCmd.Flags().StringVar(&transportOptions.NoProxy, "no-proxy", "", "comma separated hosts, domains and CIDRs to reach without proxy")
+	builderCmd.Flags().DurationVar(&transportOptions.ConnectTimeout, "connect-timeout", 30*time.Second, "timeout for connecting to download servers and registries")
+	builderCmd.Flags().DurationVar(&transportOptions.Timeout, "download-timeout", 0, "timeout for a single download request including its body, 0 for no limit")
+	builderCmd.Flags().IntVar(&transportOptions.Attempts, "download-attempts", 3, "number of times that failed downloads are attempted")
+	builderCmd.Flags().DurationVar(&transportOptions.RetryDelay, "download-retry-delay", 1*time.Second, "initial delay before retrying failed downloads (ex. 1s, 2m, etc.)")
+	builderCmd.Flags().StringVar(&logFormat, "log-format", cmd.EnvOrDefault(log.EnvLogFormat, log.FormatText), "log format, text or json")
+	builderCmd.Flags().StringVar(&failureReport, "failure-report", "", "file to write a JSON report to when staging fails")
+	builderCmd.Flags().IntVar(&failureReportLines, "failure-report-lines", failure.DefaultOutputLines, "number of output lines of the failed buildpack in the failure report")
 	_ = builderCmd.MarkFlagRequired("buildpack")
 }
 
 var builderCmd = &cobra.Command{
 	Use:          "builder",
 	SilenceUsage: true,
-	RunE: func(cobraCmd *cobra.Command, cmdArgs []string) error {
-		platformAPI := api.MustParse(PlatformAPI)
-		inputs := platform.NewLifecycleInputs(platformAPI)
+	RunE: func(cobraCmd *cobra.Command, cmdArgs []string) (err error) {
+		failures := failure.NewRecorder(failureReportLines)
+		defer func() {
+			if err == nil || failureReport == "" {
+				return
+			}
+			if writeErr := failures.Write(failureReport, err); writeErr != nil {
+				cmd.DefaultLogger.Errorf("failed to write failure report %q, error: %s\n", failureReport, writeErr.Error())
+			}
+		}()
 
-		cmd.DisableColor(inputs.NoColor)
+		// the format is set first, everything logged before would not be JSON
 		logger := log.NewLogger()
+		logger.OnError(failures.Error)
+		if err := logger.SetFormat(logFormat); err != nil {
+			logger.Errorf("failed to set log format, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+		// the returned error is logged by Execute instead of printed by cobra
+		cobraCmd.SilenceErrors = logFormat == log.FormatJSON
+
+		if err := cmd.VerifyPlatformAPI(platformAPIVersion, logger); err != nil {
+			logger.Errorf("failed verifying platform API, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+
+		platformAPI := api.MustParse(platformAPIVersion)
+		inputs := platform.NewLifecycleInputs(platformAPI)
+		if execEnv != platform.DefaultExecEnv && !platformAPI.AtLeast(staging.ExecEnvPlatformAPI) {
+			logger.Warnf("Execution environment %q is only passed to buildpacks with platform API %s, buildpacks and processes are selected by it from platform API %s", execEnv, platformAPI, staging.ExecEnvPlatformAPI)
+		}
+
+		cmd.DisableColor(inputs.NoColor || logFormat == log.FormatJSON)
 		if err := logger.SetLevel(inputs.LogLevel); err != nil {
 			logger.Errorf("failed to set log level to %q, error: %s\n", inputs.LogLevel, err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 		}
 
-		if err := credhub.InterpolateServiceRefs(credhubConnectionAttempts, credhubRetryDelay); err != nil {
+		if err := credhub.InterpolateServiceRefs(credhubRetry(), logger); err != nil {
 			logger.Error(err.Error())
-			return errors.ErrGenericBuild
+			return credhub.ExitError(err, errors.ErrGenericBuild, errors.PhasePreparing)
 		}
 
-		databaseUrl, err := databaseuri.ParseDatabaseURI(os.Getenv("VCAP_SERVICES"))
-		if err != nil {
-			logger.Errorf("failed to parse database URI, error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+		if err := credhub.InterpolateEnv(credhubInterpolateEnv, credhubRetry(), logger); err != nil {
+			logger.Error(err.Error())
+			return credhub.ExitError(err, errors.ErrGenericBuild, errors.PhasePreparing)
 		}
-		if databaseUrl != "" {
-			err = os.Setenv("DATABASE_URL", databaseUrl)
-			if err != nil {
-				logger.Errorf("Unable to set DATABASE_URL envirionment variable: %v", err)
-				return errors.ErrGenericBuild
-			}
+
+		err = databaseuri.Export(logger)
+		if err != nil {
+			logger.Errorf("failed to set database URL, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 		}
 
 		tempDirs := map[string]*string{
@@ -116,64 +187,135 @@ var builderCmd = &cobra.Command{
 			"buildpacks":     &buildpacksDir,
 			"extensions":     &extensionsDir,
 			"download-cache": &downloadCacheDir,
+			"inline":         &inlineBuildpacksDir,
 		}
 
 		for name, dir := range tempDirs {
 			if *dir, err = os.MkdirTemp("", name); err != nil {
 				logger.Errorf("failed to create folder %q, error: %s\n", name, err.Error())
-				return errors.ErrGenericBuild
+				return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 			}
 		}
 
 		for _, dir := range []string{layersDir, cacheDir} {
 			if err := os.MkdirAll(dir, 0o755); err != nil {
 				logger.Errorf("failed to create %q, error: %s\n", dir, err.Error())
-				return errors.ErrGenericBuild
+				return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+			}
+		}
+
+		descriptor, err := project.Read(workspaceDir, logger)
+		if err != nil {
+			logger.Errorf("failed to read project descriptor, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+
+		if descriptor != nil {
+			if err := descriptor.FilterFiles(workspaceDir); err != nil {
+				logger.Errorf("failed to apply project include/exclude, error: %s\n", err.Error())
+				return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+			}
+
+			if err := descriptor.WriteBuildEnv(platformDir); err != nil {
+				logger.Errorf("failed to write project build env, error: %s\n", err.Error())
+				return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+			}
+
+			buildpackList, autoDetect, err = descriptor.Buildpacks(buildpackList, autoDetect, inlineBuildpacksDir, logger)
+			if err != nil {
+				logger.Errorf("failed to resolve project buildpacks, error: %s\n", err.Error())
+				return errors.Wrap(errors.ErrDownloadingBuildpack, errors.PhasePreparing, err)
 			}
 		}
 
 		if err := staging.CreateEnvFiles(platformDir, envVarNames); err != nil {
 			logger.Errorf("failed to write env var files, error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+
+		if err := bindings.Write(os.Getenv("VCAP_SERVICES"), filepath.Join(platformDir, "bindings")); err != nil {
+			logger.Errorf("failed to write service bindings, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+
+		serviceEnv, err := serviceenv.Load(serviceenv.Paths(serviceenv.OperatorDir, workspaceDir)...)
+		if err != nil {
+			logger.Errorf("failed to render service env vars, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+		serviceEnvNames, err := serviceenv.WriteEnvFiles(platformDir, serviceEnv)
+		if err != nil {
+			logger.Errorf("failed to write service env var files, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+		if len(serviceEnvNames) > 0 {
+			logger.Infof("Passing env vars from service mappings: %s", strings.Join(serviceEnvNames, ", "))
+		}
+
+		if credhubInterpolateFiles {
+			if err := credhub.InterpolateEnvFiles(filepath.Join(platformDir, "env"), credhubRetry(), logger); err != nil {
+				logger.Error(err.Error())
+				return credhub.ExitError(err, errors.ErrGenericBuild, errors.PhasePreparing)
+			}
 		}
 
 		orderFile, err := os.CreateTemp("", "order.toml")
 		if err != nil {
 			logger.Errorf("failed to create 'order.toml', error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 		}
 
+		target, err := buildTarget()
+		if err != nil {
+			logger.Errorf("failed to determine target, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
+		}
+		logger.Infof("Building for target %s", buildpacks.FormatTarget(target))
+
 		analyzePath := filepath.Join(layersDir, "analyzed.toml")
-		analyzeMD, err := writeAnalyzed(analyzePath, logger)
+		analyzeMD, err := writeAnalyzed(analyzePath, target, logger)
 		if err != nil {
 			logger.Errorf("failed to create 'analyzed.toml', error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 		}
 
-		creds, err := keychain.FromEnv()
+		httpTransport, err := transport.New(transportOptions, logger)
+		if err != nil {
+			logger.Errorf("failed to configure HTTP transport, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrDownloadingBuildpack, errors.PhasePreparing, err)
+		}
+		sources := registrySources
+		sources.Transport = httpTransport
+		creds, err := sources.Keychain()
 		if err != nil {
-			logger.Errorf("failed to parse %s environment variable, error: %s\n", keychain.CnbCredentialsEnv, err.Error())
-			return errors.ErrGenericBuild
+			logger.Errorf("failed to load registry credentials, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhasePreparing, err)
 		}
 
+		failures.Phase(errors.PhaseDownloading)
 		buildpackList, err = buildpacks.Translate(buildpackList, systemBuildpacksDir, logger)
 		if err != nil {
 			logger.Errorf("failed to translate buildpack locations %#v, error: %s\n", buildpackList, err.Error())
-			return errors.ErrDownloadingBuildpack
+			return errors.Wrap(errors.ErrDownloadingBuildpack, errors.PhaseDownloading, err)
 		}
 
 		err = buildpacks.DownloadBuildpacks(
 			buildpackList,
 			buildpacksDir,
-			image.NewFetcher(logger, nil, image.WithKeychain(creds)),
-			blob.NewDownloader(logger, downloadCacheDir, blob.WithClient(keychain.NewHTTPClient(creds))),
+			keychain.NewImageFetcher(creds, httpTransport),
+			blob.NewDownloader(logger, downloadCacheDir, blob.WithClient(keychain.NewHTTPClient(creds, httpTransport, logger))),
 			orderFile,
 			autoDetect,
 			logger,
 		)
 		if err != nil {
 			logger.Errorf("failed to download buildpacks, error: %s\n", err.Error())
-			return errors.ErrDownloadingBuildpack
+			return errors.Wrap(errors.ErrDownloadingBuildpack, errors.PhaseDownloading, err)
+		}
+
+		if err := buildpacks.CheckTargets(buildpacksDir, orderFile.Name(), target, autoDetect, logger); err != nil {
+			logger.Errorf("failed target compatibility check, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrDetecting, errors.PhaseDetecting, err)
 		}
 
 		dirStore := platform.NewDirStore(buildpacksDir, extensionsDir)
@@ -193,25 +335,34 @@ var builderCmd = &cobra.Command{
 			OrderPath:     orderFile.Name(),
 			PlatformDir:   platformDir,
 			CacheDir:      cacheDir,
+			ExecEnv:       execEnv,
 			UseDaemon:     false,
 		}, logger)
 		if err != nil {
 			logger.Errorf("failed creating detector, error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseDetecting, err)
 		}
+		detector.Executor = logger.DetectExecutor(failures.DetectExecutor(detector.Executor))
 
 		logger.Phase("DETECTING")
+		failures.Phase(errors.PhaseDetecting)
 		bGroup, plan, err := detector.Detect()
 		if err != nil {
 			logger.Errorf("failed 'detect' phase, error: %s\n", err.Error())
-			return errors.ErrDetecting
+			return errors.Wrap(errors.ErrDetecting, errors.PhaseDetecting, err).WithBuildpack(failures.Buildpack())
+		}
+
+		if err := buildpacks.WriteV2GroupPositions(buildpacksDir, filepath.Join(filepath.Dir(workspaceDir), "deps"), bGroup.Group); err != nil {
+			logger.Errorf("failed to prepare v2 buildpacks, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrDetecting, errors.PhaseDetecting, err)
 		}
 
 		logger.Phase("RESTORING")
+		failures.Phase(errors.PhaseRestoring)
 		cache, err := cache.NewVolumeCache(cacheDir, logger)
 		if err != nil {
 			logger.Errorf("failed to initialise cache, error: %s\n", err.Error())
-			return errors.ErrRestoring
+			return errors.Wrap(errors.ErrRestoring, errors.PhaseRestoring, err)
 		}
 
 		restorer := phase.Restorer{
@@ -222,41 +373,48 @@ var builderCmd = &cobra.Command{
 		}
 		if err := restorer.Restore(cache); err != nil {
 			logger.Errorf("failed to restore cached layers, error: %s\n", err.Error())
-			return errors.ErrRestoring
+			return errors.Wrap(errors.ErrRestoring, errors.PhaseRestoring, err)
 		}
 
 		bldr := phase.Builder{
 			AppDir:        workspaceDir,
 			LayersDir:     layersDir,
 			PlatformDir:   platformDir,
-			BuildExecutor: &buildpack.DefaultBuildExecutor{},
+			BuildExecutor: failures.BuildExecutor(logger.BuildExecutor(&buildpack.DefaultBuildExecutor{})),
 			DirStore:      dirStore,
 			Group:         bGroup,
 			Logger:        logger,
-			Out:           os.Stdout,
-			Err:           os.Stderr,
+			Out:           logger.Output("stdout", os.Stdout),
+			Err:           logger.Output("stderr", os.Stderr),
 			Plan:          plan,
 			PlatformAPI:   platformAPI,
 			AnalyzeMD:     analyzeMD,
+			ExecEnv:       execEnv,
 		}
 
 		logger.Phase("BUILDING")
+		failures.Phase(errors.PhaseBuilding)
 		buildMeta, err := bldr.Build()
 		if err != nil {
 			logger.Errorf("failed 'build' phase, error: %s\n", err.Error())
-			return errors.ErrBuilding
+			return errors.Wrap(errors.ErrBuilding, errors.PhaseBuilding, err).WithBuildpack(failures.Buildpack())
 		}
 		ensureWebProcessType(buildMeta)
 
 		if err := files.Handler.WriteBuildMetadata(launch.GetMetadataFilePath(layersDir), buildMeta); err != nil {
 			logger.Errorf("failed writing build metadata, error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseBuilding, err)
+		}
+
+		if err := staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: platformAPI.String(), ExecEnv: execEnv}); err != nil {
+			logger.Errorf("failed writing droplet metadata, error: %s\n", err.Error())
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseBuilding, err)
 		}
 
 		artifactsDir, err := os.MkdirTemp("", "lifecycle.exporter.layer")
 		if err != nil {
 			logger.Errorf("create temp directory for artifacts, error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseExporting, err)
 		}
 
 		exporter := phase.Exporter{
@@ -273,15 +431,16 @@ var builderCmd = &cobra.Command{
 		}
 
 		logger.Phase("EXPORTING")
+		failures.Phase(errors.PhaseExporting)
 		if err := exporter.Cache(layersDir, cache); err != nil {
 			logger.Errorf("failed to save cached layers, error: %s\n", err.Error())
-			return errors.ErrExporting
+			return errors.Wrap(errors.ErrExporting, errors.PhaseExporting, err)
 		}
 
 		cacheOutFile, err := os.OpenFile(cacheOutputFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 		if err != nil {
 			logger.Errorf("Failed to open %q, error: %s\n", cacheOutputFile, err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseExporting, err)
 		}
 		defer cacheOutFile.Close()
 
@@ -290,26 +449,27 @@ var builderCmd = &cobra.Command{
 
 		if err := archive.FromDirectory(cacheDir, tar.NewWriter(cgw)); err != nil {
 			logger.Errorf("failed to save archive cache folder, error: %s\n", err.Error())
-			return errors.ErrExporting
+			return errors.Wrap(errors.ErrExporting, errors.PhaseExporting, err)
 		}
 
-		resultData := staging.StagingResultFromMetadata(buildMeta)
+		resultData := staging.StagingResultFromMetadata(buildMeta, execEnv, platformAPI)
+		resultData.Target = &target
 		resultBytes, err := json.Marshal(resultData)
 		if err != nil {
 			logger.Errorf("failed to marshal '/tmp/result.json', error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseExporting, err)
 		}
 
 		if err := os.WriteFile(result, resultBytes, 0o644); err != nil {
 			logger.Errorf("failed to write '/tmp/result.json', error: %s\n", err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseExporting, err)
 		}
 		logger.Infof("result file saved to %q", result)
 
 		dropletOutFile, err := os.OpenFile(dropletFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 		if err != nil {
 			logger.Errorf("failed to open %q, error: %s\n", dropletFile, err.Error())
-			return errors.ErrGenericBuild
+			return errors.Wrap(errors.ErrGenericBuild, errors.PhaseExporting, err)
 		}
 		defer dropletOutFile.Close()
 
@@ -318,11 +478,11 @@ var builderCmd = &cobra.Command{
 
 		if err := staging.RemoveBuildOnlyLayers(layersDir, bGroup.Group, logger); err != nil {
 			logger.Errorf("failed to remove build-only layers, error: %s\n", err.Error())
-			return errors.ErrExporting
+			return errors.Wrap(errors.ErrExporting, errors.PhaseExporting, err)
 		}
 		if err := archive.FromDirectory(filepath.Dir(workspaceDir), tar.NewWriter(dgw)); err != nil {
 			logger.Errorf("failed 'export' phase, error: %s\n", err.Error())
-			return errors.ErrExporting
+			return errors.Wrap(errors.ErrExporting, errors.PhaseExporting, err)
 		}
 		logger.Infof("droplet archive saved to %q", dropletFile)
 
@@ -330,13 +490,19 @@ var builderCmd = &cobra.Command{
 	},
 }
 
-func writeAnalyzed(path string, logger *log.Logger) (files.Analyzed, error) {
+// buildTarget returns the target passed with --target or the one detected from the stack
+func buildTarget() (files.TargetMetadata, error) {
+	if targetFlag != "" {
+		return staging.ParseTarget(targetFlag)
+	}
+
+	return staging.DetectTarget(staging.OSReleasePath)
+}
+
+func writeAnalyzed(path string, target files.TargetMetadata, logger *log.Logger) (files.Analyzed, error) {
 	analyzed := files.Analyzed{
 		RunImage: &files.RunImage{
-			TargetMetadata: &files.TargetMetadata{
-				OS:   "linux",
-				Arch: runtime.GOARCH,
-			},
+			TargetMetadata: &target,
 		},
 	}
 
@@ -364,3 +530,12 @@ func ensureWebProcessType(buildMeta *files.BuildMetadata) {
 		buildMeta.Processes = append(buildMeta.Processes, defaultProcess)
 	}
 }
+
+func credhubRetry() credhub.Retry {
+	return credhub.Retry{
+		Attempts:  credhubConnectionAttempts,
+		Delay:     credhubRetryDelay,
+		Deadline:  credhubDeadline,
+		Transport: transportOptions,
+	}
+}
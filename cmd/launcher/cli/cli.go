@@ -16,33 +16,56 @@ import (
 	"github.com/spf13/cobra"
 
 	builderCli "code.cloudfoundry.org/cnbapplifecycle/cmd/builder/cli"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/bindings"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/credhub"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/databaseuri"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/serviceenv"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
 )
 
 const (
-	defaultProcessType  = "web"
-	launcherProcessType = "launcher"
+	defaultProcessType        = "web"
+	launcherProcessType       = "launcher"
+	DefaultServiceBindingRoot = "/tmp/service-bindings"
 )
 
 var (
 	credhubConnectionAttempts int
 	credhubRetryDelay         time.Duration
+	credhubDeadline           time.Duration
+	credhubInterpolateEnv     []string
+	serviceBindingRoot        string
+	logFormat                 string
 )
 
 func Execute() error {
-	return launcherCmd.Execute()
+	err := launcherCmd.Execute()
+	if err != nil && launcherCmd.SilenceErrors {
+		cmd.DefaultLogger.Error(err.Error())
+	}
+
+	return err
 }
 
 func init() {
+	// returned errors are printed with their cause
+	launcherCmd.SetErr(log.NewRedactingWriter(os.Stderr))
 	launcherCmd.Flags().IntVar(&credhubConnectionAttempts, "credhub-connection-attempts", 3, "number of times that the credhub client will attempt to connect to credhub")
 	launcherCmd.Flags().DurationVar(&credhubRetryDelay, "credhub-retry-delay", 1*time.Second, "delay duration that credhub client will wait before retries (ex. 1s, 2m, etc.)")
+	launcherCmd.Flags().DurationVar(&credhubDeadline, "credhub-deadline", 1*time.Minute, "total duration after which the credhub client gives up, 0 for no limit")
+	launcherCmd.Flags().StringSliceVar(&credhubInterpolateEnv, "credhub-interpolate-env", nil, "environment variable(s) to interpolate credhub references in")
+	launcherCmd.Flags().StringVar(&serviceBindingRoot, "service-binding-root", DefaultServiceBindingRoot, "directory to write service bindings to, empty to disable")
+	launcherCmd.Flags().StringVar(&logFormat, "log-format", cmd.EnvOrDefault(log.EnvLogFormat, log.FormatText), "log format, text or json")
 }
 
-func findLaunchProcessType(processes []launch.Process, expectedCmd string) (string, bool) {
+func findLaunchProcessType(processes []launch.Process, execEnv string, platformAPI *api.Version, expectedCmd string) (string, bool) {
 	for _, proc := range processes {
+		if !staging.ProcessEligibleForExecEnv(proc, execEnv, platformAPI) {
+			continue
+		}
+
 		command := append(proc.Command.Entries, proc.Args...)
 		if expectedCmd == strings.Join(command, " ") {
 			return proc.Type, false
@@ -56,6 +79,8 @@ var launcherCmd = &cobra.Command{
 	Use:          "launcher",
 	SilenceUsage: true,
 	RunE: func(cobraCmd *cobra.Command, cmdArgs []string) error {
+		// the returned error is logged by Execute instead of printed by cobra
+		cobraCmd.SilenceErrors = logFormat == log.FormatJSON
 		return Launch(os.Args, &LifecycleLauncher{})
 	},
 }
@@ -69,42 +94,108 @@ func verifyBuildpackAPIs(bps []launch.Buildpack) error {
 	return nil
 }
 
+// writeServiceBindings materializes VCAP_SERVICES as service bindings and points SERVICE_BINDING_ROOT to them
+func writeServiceBindings(logger *log.Logger) error {
+	services := os.Getenv("VCAP_SERVICES")
+	root := cmd.EnvOrDefault(bindings.EnvServiceBindingRoot, serviceBindingRoot)
+	if services == "" || root == "" {
+		return nil
+	}
+
+	if err := bindings.Write(services, root); err != nil {
+		return err
+	}
+
+	logger.Debugf("Wrote service bindings to %q", root)
+	return os.Setenv(bindings.EnvServiceBindingRoot, root)
+}
+
 func Launch(osArgs []string, theLauncher TheLauncher) error {
 	var md launch.Metadata
 	var args []string
 	logger := log.NewLogger()
 	defaultProc := defaultProcessType
 
+	if err := logger.SetFormat(logFormat); err != nil {
+		logger.Errorf("failed to set log format, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+	if logFormat == log.FormatJSON {
+		cmd.DisableColor(true)
+	}
+
 	osArgs = slices.DeleteFunc(osArgs, func(s string) bool {
 		return s == ""
 	})
 
-	if _, err := toml.DecodeFile(launch.GetMetadataFilePath(cmd.EnvOrDefault(platform.EnvLayersDir, builderCli.DefaultLayersPath)), &md); err != nil {
+	layersDir := cmd.EnvOrDefault(platform.EnvLayersDir, builderCli.DefaultLayersPath)
+	if _, err := toml.DecodeFile(launch.GetMetadataFilePath(layersDir), &md); err != nil {
 		logger.Errorf("failed decoding, error: %s\n", err.Error())
-		return errors.ErrLaunching
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+
+	dropletMD, err := staging.ReadDropletMetadata(layersDir)
+	if err != nil {
+		logger.Errorf("failed decoding droplet metadata, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+
+	// droplets built before the platform API was configurable use the default
+	platformAPIVersion := builderCli.DefaultPlatformAPI
+	if dropletMD.PlatformAPI != "" {
+		platformAPIVersion = dropletMD.PlatformAPI
+	}
+
+	if err := cmd.VerifyPlatformAPI(platformAPIVersion, logger); err != nil {
+		logger.Errorf("failed verifying platform API, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+	platformAPI := api.MustParse(platformAPIVersion)
+
+	// the execution environment defaults to the one the droplet was built for
+	execEnv := cmd.EnvOrDefault(platform.EnvExecEnv, dropletMD.ExecEnv)
+	if execEnv == "" {
+		execEnv = platform.DefaultExecEnv
 	}
 
 	if err := verifyBuildpackAPIs(md.Buildpacks); err != nil {
 		logger.Errorf("failed verifying buildpack API, error: %s\n", err.Error())
-		return errors.ErrLaunching
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
 	}
 
-	if err := credhub.InterpolateServiceRefs(credhubConnectionAttempts, credhubRetryDelay); err != nil {
+	if err := credhub.InterpolateServiceRefs(credhubRetry(), logger); err != nil {
 		logger.Error(err.Error())
-		return errors.ErrLaunching
+		return credhub.ExitError(err, errors.ErrLaunching, errors.PhaseLaunching)
+	}
+
+	if err := credhub.InterpolateEnv(credhubInterpolateEnv, credhubRetry(), logger); err != nil {
+		logger.Error(err.Error())
+		return credhub.ExitError(err, errors.ErrLaunching, errors.PhaseLaunching)
+	}
+
+	if err := databaseuri.Export(logger); err != nil {
+		logger.Errorf("failed to set database URL, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
 	}
 
-	databaseUrl, err := databaseuri.ParseDatabaseURI(os.Getenv("VCAP_SERVICES"))
+	if err := writeServiceBindings(logger); err != nil {
+		logger.Errorf("failed to write service bindings, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+
+	appDir := cmd.EnvOrDefault(platform.EnvAppDir, builderCli.DefaultWorkspacePath)
+	serviceEnv, err := serviceenv.Load(serviceenv.Paths(serviceenv.OperatorDir, appDir)...)
 	if err != nil {
-		logger.Errorf("failed to parse database URI, error: %s\n", err.Error())
-		return errors.ErrLaunching
-	}
-	if databaseUrl != "" {
-		err = os.Setenv("DATABASE_URL", databaseUrl)
-		if err != nil {
-			logger.Errorf("Unable to set DATABASE_URL envirionment variable: %v", err)
-			return errors.ErrLaunching
-		}
+		logger.Errorf("failed to render service env vars, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+	serviceEnvNames, err := serviceenv.Setenv(serviceEnv)
+	if err != nil {
+		logger.Errorf("failed to set service env vars, error: %s\n", err.Error())
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
+	}
+	if len(serviceEnvNames) > 0 {
+		logger.Debugf("Set env vars from service mappings: %s", strings.Join(serviceEnvNames, ", "))
 	}
 
 	var self string
@@ -117,7 +208,7 @@ func Launch(osArgs []string, theLauncher TheLauncher) error {
 		if osArgs[1] == "--" {
 			self = launcherProcessType
 		} else {
-			self, isSidecar = findLaunchProcessType(md.Processes, strings.Join(osArgs[2:], " "))
+			self, isSidecar = findLaunchProcessType(md.Processes, execEnv, platformAPI, strings.Join(osArgs[2:], " "))
 			logger.Infof("Detected process type: %q, isSidecar: %v", self, isSidecar)
 
 			if !isSidecar {
@@ -129,9 +220,10 @@ func Launch(osArgs []string, theLauncher TheLauncher) error {
 
 	launcher := &launch.Launcher{
 		DefaultProcessType: defaultProc,
-		LayersDir:          cmd.EnvOrDefault(platform.EnvLayersDir, builderCli.DefaultLayersPath),
-		AppDir:             cmd.EnvOrDefault(platform.EnvAppDir, builderCli.DefaultWorkspacePath),
-		PlatformAPI:        api.MustParse(builderCli.PlatformAPI),
+		LayersDir:          layersDir,
+		AppDir:             appDir,
+		ExecEnv:            execEnv,
+		PlatformAPI:        platformAPI,
 		Processes:          md.Processes,
 		Buildpacks:         md.Buildpacks,
 		Env:                env.NewLaunchEnv(os.Environ(), launch.ProcessDir, "/tmp/lifecycle"),
@@ -143,8 +235,16 @@ func Launch(osArgs []string, theLauncher TheLauncher) error {
 
 	if err := theLauncher.Launch(launcher, self, args); err != nil {
 		logger.Errorf("failed launching with self: %q, defaultProc: %q, args: %#v, error: %s\n", self, defaultProc, args, err.Error())
-		return errors.ErrLaunching
+		return errors.Wrap(errors.ErrLaunching, errors.PhaseLaunching, err)
 	}
 
 	return nil
 }
+
+func credhubRetry() credhub.Retry {
+	return credhub.Retry{
+		Attempts: credhubConnectionAttempts,
+		Delay:    credhubRetryDelay,
+		Deadline: credhubDeadline,
+	}
+}
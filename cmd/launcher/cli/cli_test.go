@@ -1,13 +1,48 @@
 package cli_test
 
 import (
+	"os"
+	"path/filepath"
+
 	"code.cloudfoundry.org/cnbapplifecycle/cmd/launcher/cli"
 	"code.cloudfoundry.org/cnbapplifecycle/cmd/launcher/cli/fake"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
 
+	"github.com/buildpacks/lifecycle/launch"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
 
+// execRecordingLauncher launches with the lifecycle launcher but records the process instead of executing it
+type execRecordingLauncher struct {
+	ProcessType  string
+	ExecutedArgv []string
+}
+
+func (l *execRecordingLauncher) Launch(launcher *launch.Launcher, self string, cmd []string) error {
+	proc, err := launcher.ProcessFor(cmd)
+	if err != nil {
+		return err
+	}
+	l.ProcessType = proc.Type
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	exec := func(_ string, argv []string, _ []string) error {
+		l.ExecutedArgv = argv
+		return nil
+	}
+	launcher.Exec = exec
+	launcher.Shell = &launch.BashShell{Exec: exec}
+	launcher.Setenv = func(string, string) error { return nil }
+
+	return (&cli.LifecycleLauncher{}).Launch(launcher, self, cmd)
+}
+
 var _ = Describe("Launch", func() {
 
 	Context("a valid metadata.toml", func() {
@@ -21,6 +56,7 @@ var _ = Describe("Launch", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(launcher.ExecutedSelf).To(Equal("web"))
 			Expect(launcher.ExecutedCmd).To(BeEmpty())
+			Expect(launcher.ExecutedPlatformAPI).To(Equal("0.14"))
 		})
 
 		It("launches a second app process", func() {
@@ -53,7 +89,137 @@ var _ = Describe("Launch", func() {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("launching failed"))
 		})
+
+		It("writes service bindings from VCAP_SERVICES", func() {
+			bindingRoot := filepath.Join(GinkgoT().TempDir(), "bindings")
+			GinkgoT().Setenv("SERVICE_BINDING_ROOT", bindingRoot)
+			GinkgoT().Setenv("VCAP_SERVICES", `{"redis": [{"name": "cache", "credentials": {"password": "secret"}}]}`)
+
+			launcher := fake.FakeLifecycleLauncher{}
+			err := cli.Launch([]string{"/tmp/launcher", "app", "python app.py", ""}, &launcher)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Getenv("SERVICE_BINDING_ROOT")).To(Equal(bindingRoot))
+
+			content, err := os.ReadFile(filepath.Join(bindingRoot, "cache", "password"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("secret"))
+		})
+
+		It("sets env vars from service mappings", func() {
+			appDir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(appDir, "service-env.toml"), []byte(`
+[[env]]
+name = "REDIS_PASSWORD"
+label = "redis"
+template = "{{ .Credentials.password }}"
+`), 0o644)).To(Succeed())
+			GinkgoT().Setenv("CNB_APP_DIR", appDir)
+			GinkgoT().Setenv("SERVICE_BINDING_ROOT", GinkgoT().TempDir())
+			GinkgoT().Setenv("VCAP_SERVICES", `{"redis": [{"name": "cache", "credentials": {"password": "secret"}}]}`)
+			GinkgoT().Setenv("REDIS_PASSWORD", "")
+			Expect(os.Unsetenv("REDIS_PASSWORD")).To(Succeed())
+
+			launcher := fake.FakeLifecycleLauncher{}
+			err := cli.Launch([]string{"/tmp/launcher", "app", "python app.py", ""}, &launcher)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Getenv("REDIS_PASSWORD")).To(Equal("secret"))
+		})
 	})
+
+	Context("a droplet with a recorded platform API", func() {
+		var layersDir string
+
+		BeforeEach(func() {
+			layersDir = GinkgoT().TempDir()
+			metadata, err := os.ReadFile("../../../integration/testdata/validMetadata/config/metadata.toml")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.MkdirAll(filepath.Join(layersDir, "config"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "config", "metadata.toml"), metadata, 0o644)).To(Succeed())
+
+			GinkgoT().Setenv("CNB_LAYERS_DIR", layersDir)
+		})
+
+		It("launches with a supported platform API", func() {
+			Expect(staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: "0.13"})).To(Succeed())
+
+			launcher := fake.FakeLifecycleLauncher{}
+			err := cli.Launch([]string{"/tmp/launcher", "app", "python app.py", ""}, &launcher)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launcher.ExecutedPlatformAPI).To(Equal("0.13"))
+		})
+
+		It("fails for an unsupported platform API", func() {
+			Expect(staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: "0.1"})).To(Succeed())
+
+			launcher := fake.FakeLifecycleLauncher{}
+			err := cli.Launch([]string{"/tmp/launcher", "app", "python app.py", ""}, &launcher)
+			Expect(err).To(MatchError(ContainSubstring("launching failed")))
+		})
+	})
+
+	Context("processes for an execution environment", func() {
+		var layersDir string
+
+		BeforeEach(func() {
+			layersDir = GinkgoT().TempDir()
+			Expect(os.MkdirAll(filepath.Join(layersDir, "config"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "config", "metadata.toml"), []byte(`
+[[processes]]
+  type = "web"
+  command = ["rails server"]
+  exec-env = ["production"]
+
+[[processes]]
+  type = "test"
+  command = ["rails server"]
+  exec-env = ["test"]
+
+[[processes]]
+  type = "migrate"
+  command = ["rake db:migrate"]
+  exec-env = ["test"]
+`), 0o644)).To(Succeed())
+			Expect(staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: "0.15"})).To(Succeed())
+
+			GinkgoT().Setenv("CNB_LAYERS_DIR", layersDir)
+			GinkgoT().Setenv("CNB_APP_DIR", GinkgoT().TempDir())
+		})
+
+		It("selects the production process by default", func() {
+			launcher := fake.FakeLifecycleLauncher{}
+			Expect(cli.Launch([]string{"/tmp/launcher", "app", "rails server", ""}, &launcher)).To(Succeed())
+			Expect(launcher.ExecutedSelf).To(Equal("web"))
+			Expect(launcher.ExecutedExecEnv).To(Equal("production"))
+		})
+
+		It("selects the process of the execution environment set in CNB_EXEC_ENV", func() {
+			GinkgoT().Setenv("CNB_EXEC_ENV", "test")
+
+			launcher := fake.FakeLifecycleLauncher{}
+			Expect(cli.Launch([]string{"/tmp/launcher", "app", "rails server", ""}, &launcher)).To(Succeed())
+			Expect(launcher.ExecutedSelf).To(Equal("test"))
+			Expect(launcher.ExecutedExecEnv).To(Equal("test"))
+		})
+
+		It("launches the process selected by the lifecycle launcher", func() {
+			GinkgoT().Setenv("CNB_EXEC_ENV", "test")
+
+			launcher := execRecordingLauncher{}
+			Expect(cli.Launch([]string{"/tmp/launcher", "app", "rake db:migrate", ""}, &launcher)).To(Succeed())
+			Expect(launcher.ProcessType).To(Equal("migrate"))
+			Expect(launcher.ExecutedArgv).To(ContainElement(ContainSubstring("rake db:migrate")))
+		})
+
+		It("does not filter processes below platform API 0.15 like the lifecycle launcher", func() {
+			Expect(staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: "0.14"})).To(Succeed())
+
+			launcher := execRecordingLauncher{}
+			Expect(cli.Launch([]string{"/tmp/launcher", "app", "rake db:migrate", ""}, &launcher)).To(Succeed())
+			Expect(launcher.ProcessType).To(Equal("migrate"))
+			Expect(launcher.ExecutedArgv).To(ContainElement(ContainSubstring("rake db:migrate")))
+		})
+	})
+
 	Context("an invalid metadata.toml", func() {
 		BeforeEach(func() {
 			GinkgoT().Setenv("CNB_LAYERS_DIR", "../../../integration/testdata/invalidMetadata")
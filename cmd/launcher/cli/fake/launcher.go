@@ -9,8 +9,10 @@ import (
 )
 
 type FakeLifecycleLauncher struct {
-	ExecutedCmd  string
-	ExecutedSelf string
+	ExecutedCmd         string
+	ExecutedSelf        string
+	ExecutedPlatformAPI string
+	ExecutedExecEnv     string
 }
 
 var _ cli.TheLauncher = &FakeLifecycleLauncher{}
@@ -21,6 +23,8 @@ func (l *FakeLifecycleLauncher) Launch(launcher *launch.Launcher, self string, c
 	}
 	l.ExecutedCmd = strings.Join(cmd, " ")
 	l.ExecutedSelf = self
+	l.ExecutedPlatformAPI = launcher.PlatformAPI.String()
+	l.ExecutedExecEnv = launcher.ExecEnv
 
 	return nil
 }
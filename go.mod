@@ -8,9 +8,11 @@ require (
 	code.cloudfoundry.org/credhub-cli v0.0.0-20260727130059-9e78db728bcf
 	github.com/BurntSushi/toml v1.6.0
 	github.com/apex/log v1.9.0
+	github.com/buildpacks/imgutil v0.0.0-20260415151438-73856e68b72b
 	github.com/buildpacks/lifecycle v0.21.14
 	github.com/buildpacks/pack v0.40.8
 	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/docker/go-units v0.5.0
 	github.com/google/go-containerregistry v0.21.8
 	github.com/jarcoal/httpmock v1.4.2
 	github.com/moby/moby/api v1.55.0
@@ -86,7 +88,6 @@ require (
 	github.com/bombsimon/wsl/v5 v5.8.0 // indirect
 	github.com/breml/bidichk v0.3.3 // indirect
 	github.com/breml/errchkjson v0.4.1 // indirect
-	github.com/buildpacks/imgutil v0.0.0-20260415151438-73856e68b72b // indirect
 	github.com/butuzov/ireturn v0.4.1 // indirect
 	github.com/butuzov/mirror v1.3.0 // indirect
 	github.com/catenacyber/perfsprint v0.10.1 // indirect
@@ -125,7 +126,6 @@ require (
 	github.com/docker/docker-credential-helpers v0.9.7 // indirect
 	github.com/docker/go-connections v0.7.0 // indirect
 	github.com/docker/go-metrics v0.0.1 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/ebitengine/purego v0.10.0 // indirect
 	github.com/ettle/strcase v0.2.0 // indirect
 	github.com/fatih/color v1.19.0 // indirect
@@ -0,0 +1,137 @@
+package bindings
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
+)
+
+const (
+	// EnvServiceBindingRoot is the root directory of service bindings as defined by the service binding spec
+	EnvServiceBindingRoot = "SERVICE_BINDING_ROOT"
+
+	userProvidedLabel = "user-provided"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Write materializes the services from VCAP_SERVICES as service bindings in root, every service instance becomes a
+// directory named after the instance with "type", "provider" and one file per credential
+func Write(services string, root string) error {
+	if services == "" {
+		return nil
+	}
+
+	data, err := vcap.ParseServices(services, vcap.Strict)
+	if err != nil {
+		return err
+	}
+
+	// the names of named services are reserved first, so a nameless service cannot take the name of a real one
+	reserved := map[string]bool{}
+	for _, svc := range data.All() {
+		if name := serviceName(svc); name != "" {
+			reserved[name] = true
+		}
+	}
+
+	taken := map[string]bool{}
+	for _, named := range []bool{true, false} {
+		for _, label := range slices.Sorted(maps.Keys(data)) {
+			for i, svc := range data[label] {
+				name := serviceName(svc)
+				if (name != "") != named {
+					continue
+				}
+				if name == "" {
+					name = fmt.Sprintf("%s-%d", invalidNameChars.ReplaceAllString(svc.Label, "-"), i)
+				}
+
+				name = uniqueName(name, taken, reserved)
+				taken[name] = true
+
+				if err := writeBinding(filepath.Join(root, name), svc); err != nil {
+					return fmt.Errorf("failed to write binding %q: %w", name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// bindingType is the label of the service, user provided services use their first tag
+func bindingType(svc vcap.Service) string {
+	if svc.Label == userProvidedLabel && len(svc.Tags) > 0 {
+		return svc.Tags[0]
+	}
+
+	return svc.Label
+}
+
+// serviceName returns the name of the service usable as directory name, or an empty string
+func serviceName(svc vcap.Service) string {
+	name := invalidNameChars.ReplaceAllString(svc.Name, "-")
+	if strings.Trim(name, ".") == "" {
+		return ""
+	}
+
+	return name
+}
+
+// uniqueName numbers name when it is taken, numbered names avoid the reserved names of other services
+func uniqueName(name string, taken, reserved map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+
+	unique := name
+	for i := 1; taken[unique] || reserved[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+
+	return unique
+}
+
+func writeBinding(dir string, svc vcap.Service) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	files := map[string]string{
+		"type":     bindingType(svc),
+		"provider": svc.Label,
+	}
+
+	for key, value := range svc.Credentials {
+		if key == "type" || key == "provider" || invalidNameChars.MatchString(key) || strings.Trim(key, ".") == "" {
+			continue
+		}
+
+		if s, ok := value.(string); ok {
+			files[key] = s
+			continue
+		}
+
+		content, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		files[key] = string(content)
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
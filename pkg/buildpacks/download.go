@@ -2,6 +2,7 @@ package buildpacks
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"runtime"
 	"slices"
@@ -32,11 +33,23 @@ func DownloadBuildpacks(buildpacks []string, buildpacksDir string, imageFetcher
 		},
 	}
 
+	downloader = &blobCache{Downloader: downloader, blobs: map[string]blob.Blob{}}
 	bpDownloader := buildpack.NewDownloader(logger, imageFetcher, downloader, nil)
 
 	logger.Infof("Using buildpacks: %s", strings.Join(buildpacks, ", "))
 	for _, bp := range buildpacks {
-		mainBp, depBps, err := bpDownloader.Download(context.Background(), bp, downloadOptions)
+		var mainBp buildpack.BuildModule
+		var depBps []buildpack.BuildModule
+		var err error
+
+		if isOCILayout(bp) {
+			mainBp, depBps, err = fetchOCILayout(bp)
+		} else {
+			mainBp, err = downloadV2Buildpack(bp, downloader, autoDetect, logger)
+			if err == nil && mainBp == nil {
+				mainBp, depBps, err = bpDownloader.Download(context.Background(), bp, downloadOptions)
+			}
+		}
 		if err != nil {
 			return err
 		}
@@ -52,6 +65,59 @@ func DownloadBuildpacks(buildpacks []string, buildpacksDir string, imageFetcher
 	return extractBuildpacks(removeDuplicates(fetchedBps), buildpacksDir)
 }
 
+// downloadV2Buildpack returns a shimmed CNB buildpack when bp points to a Cloud Foundry v2 buildpack, or nil otherwise
+func downloadV2Buildpack(bp string, downloader blob.Downloader, autoDetect bool, logger *log.Logger) (buildpack.BuildModule, error) {
+	locatorType, err := buildpack.GetLocatorType(bp, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if locatorType != buildpack.URILocator {
+		return nil, nil
+	}
+
+	b, err := downloader.Download(context.Background(), bp)
+	if err != nil {
+		return nil, fmt.Errorf("downloading buildpack from %q: %w", bp, err)
+	}
+
+	isV2, err := isV2Buildpack(b)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting buildpack from %q: %w", bp, err)
+	}
+	if !isV2 {
+		return nil, nil
+	}
+
+	mainBp, err := shimV2Buildpack(bp, b, autoDetect)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Using Cloud Foundry v2 buildpack %q as %s", bp, mainBp.Descriptor().Info().FullName())
+	return mainBp, nil
+}
+
+// blobCache hands out blobs that were already downloaded to check for v2 buildpacks, so that pack does not
+// download them a second time
+type blobCache struct {
+	blob.Downloader
+	blobs map[string]blob.Blob
+}
+
+func (c *blobCache) Download(ctx context.Context, pathOrURI string) (blob.Blob, error) {
+	if b, ok := c.blobs[pathOrURI]; ok {
+		return b, nil
+	}
+
+	b, err := c.Downloader.Download(ctx, pathOrURI)
+	if err != nil {
+		return nil, err
+	}
+
+	c.blobs[pathOrURI] = b
+	return b, nil
+}
+
 func appendToOrder(order lifecycle.Order, bp dist.ModuleInfo, autoDetect bool) lifecycle.Order {
 	groupElement := lifecycle.GroupElement{
 		ID:       bp.ID,
@@ -3,6 +3,7 @@ package buildpacks_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -54,6 +55,28 @@ func (f fakeDownloader) Download(ctx context.Context, pathOrURI string) (blob.Bl
 	return &fakeBlob{id: id}, nil
 }
 
+type countingDownloader struct {
+	blob.Downloader
+	downloads map[string]int
+}
+
+func (c *countingDownloader) Download(ctx context.Context, pathOrURI string) (blob.Blob, error) {
+	c.downloads[pathOrURI]++
+	return c.Downloader.Download(ctx, pathOrURI)
+}
+
+type failingBlob struct{}
+
+func (failingBlob) Open() (io.ReadCloser, error) {
+	return nil, errors.New("corrupted blob")
+}
+
+type failingBlobDownloader struct{}
+
+func (failingBlobDownloader) Download(context.Context, string) (blob.Blob, error) {
+	return failingBlob{}, nil
+}
+
 var _ = Describe("DownloadBuildpacks", func() {
 	var err error
 	var logger = log.NewLogger()
@@ -138,6 +161,20 @@ var _ = Describe("DownloadBuildpacks", func() {
 		Expect(err).NotTo(HaveOccurred())
 	})
 
+	It("downloads every buildpack once", func() {
+		counting := &countingDownloader{Downloader: downloader, downloads: map[string]int{}}
+		err = buildpacks.DownloadBuildpacks([]string{"file:/buildpack1", "file:/buildpack2"}, buildpacksDir, nil, counting, orderFile, false, logger)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(counting.downloads).To(Equal(map[string]int{"file:/buildpack1": 1, "file:/buildpack2": 1}))
+	})
+
+	It("fails when the downloaded buildpack cannot be inspected", func() {
+		err = buildpacks.DownloadBuildpacks([]string{"file:/buildpack1"}, buildpacksDir, nil, failingBlobDownloader{}, orderFile, false, logger)
+
+		Expect(err).To(MatchError(ContainSubstring("corrupted blob")))
+	})
+
 	It("works for duplicated buildpacks", func() {
 		err = buildpacks.DownloadBuildpacks([]string{"file:/buildpack", "file:/buildpack"}, buildpacksDir, nil, downloader, orderFile, false, logger)
 
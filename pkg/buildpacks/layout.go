@@ -0,0 +1,221 @@
+package buildpacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/buildpacks/pack/pkg/buildpack"
+	"github.com/buildpacks/pack/pkg/dist"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+const (
+	OCILayoutPrefix = "oci-layout://"
+
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+func isOCILayout(bp string) bool {
+	return strings.HasPrefix(bp, OCILayoutPrefix)
+}
+
+// parseOCILayout splits an "oci-layout:///path[:tag]" locator into the layout directory and the optional tag
+func parseOCILayout(locator string) (string, string) {
+	path := strings.TrimPrefix(locator, OCILayoutPrefix)
+
+	i := strings.LastIndex(path, ":")
+	if i == -1 || strings.Contains(path[i:], "/") {
+		return path, ""
+	}
+
+	return path[:i], path[i+1:]
+}
+
+func fetchOCILayout(locator string) (buildpack.BuildModule, []buildpack.BuildModule, error) {
+	path, tag := parseOCILayout(locator)
+
+	lp, err := layout.FromPath(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading OCI layout %q: %w", path, err)
+	}
+
+	index, err := lp.ImageIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading index of OCI layout %q: %w", path, err)
+	}
+
+	img, err := findImage(index, tag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("selecting image from OCI layout %q: %w", path, err)
+	}
+
+	return buildpacksFromImage(img)
+}
+
+// findImage returns the image tagged with tag, or the first image when no tag is given. Nested indexes
+// are resolved to the image matching the platform the builder runs on.
+func findImage(index v1.ImageIndex, tag string) (v1.Image, error) {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, desc := range manifest.Manifests {
+		if tag != "" && desc.Annotations[refNameAnnotation] != tag {
+			continue
+		}
+
+		if desc.Platform != nil && !desc.Platform.Satisfies(v1.Platform{OS: "linux", Architecture: runtime.GOARCH}) {
+			continue
+		}
+
+		switch {
+		case desc.MediaType.IsImage():
+			return index.Image(desc.Digest)
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			return findImage(child, "")
+		}
+	}
+
+	if tag != "" {
+		return nil, fmt.Errorf("no image tagged %q found", tag)
+	}
+
+	return nil, fmt.Errorf("no image for platform linux/%s found", runtime.GOARCH)
+}
+
+// buildpacksFromImage decomposes a buildpackage image into the order buildpack and all of its dependencies. It
+// follows pack's extractBuildpacks, which is not exported: a flattened buildpackage stores all of its buildpacks in
+// one layer, the first buildpack referencing the layer carries its content and the others an empty tar.
+func buildpacksFromImage(img v1.Image) (buildpack.BuildModule, []buildpack.BuildModule, error) {
+	pkg := &imagePackage{img: img}
+
+	md := buildpack.Metadata{}
+	if err := unmarshalLabel(pkg, buildpack.MetadataLabel, &md); err != nil {
+		return nil, nil, err
+	}
+
+	bpLayers := dist.ModuleLayers{}
+	if err := unmarshalLabel(pkg, dist.BuildpackLayersLabel, &bpLayers); err != nil {
+		return nil, nil, err
+	}
+
+	var mainBp buildpack.BuildModule
+	depBps := []buildpack.BuildModule{}
+	processedDiffIDs := map[string]bool{}
+	for id, versions := range bpLayers {
+		for version, info := range versions {
+			desc := dist.BuildpackDescriptor{
+				WithAPI: info.API,
+				WithInfo: dist.ModuleInfo{
+					ID:       id,
+					Version:  version,
+					Homepage: info.Homepage,
+					Name:     info.Name,
+				},
+				WithStacks:  info.Stacks,
+				WithTargets: info.Targets,
+				WithOrder:   info.Order,
+			}
+
+			blob := &layerBlob{pkg: pkg, desc: desc.Info(), diffID: info.LayerDiffID, empty: processedDiffIDs[info.LayerDiffID]}
+			processedDiffIDs[info.LayerDiffID] = true
+
+			if desc.Info().Match(md.ModuleInfo) {
+				mainBp = buildpack.FromBlob(&desc, blob)
+			} else {
+				depBps = append(depBps, buildpack.FromBlob(&desc, blob))
+			}
+		}
+	}
+
+	if mainBp == nil {
+		return nil, nil, fmt.Errorf("buildpack %q not found in label %q", md.FullName(), dist.BuildpackLayersLabel)
+	}
+
+	return mainBp, depBps, nil
+}
+
+func unmarshalLabel(pkg buildpack.Package, name string, v any) error {
+	value, err := pkg.Label(name)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		return fmt.Errorf("could not find label %q", name)
+	}
+
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return fmt.Errorf("failed to unmarshal label %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// imagePackage is the buildpack.Package of an image, serialized like pack's syncPkg as the blobs of all buildpacks
+// read from the same image
+type imagePackage struct {
+	mu  sync.Mutex
+	img v1.Image
+}
+
+var _ buildpack.Package = (*imagePackage)(nil)
+
+func (p *imagePackage) Label(name string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	config, err := p.img.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	return config.Config.Labels[name], nil
+}
+
+func (p *imagePackage) GetLayer(diffID string) (io.ReadCloser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash, err := v1.NewHash(diffID)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := p.img.LayerByDiffID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.Uncompressed()
+}
+
+type layerBlob struct {
+	pkg    buildpack.Package
+	desc   dist.ModuleInfo
+	diffID string
+	empty  bool
+}
+
+func (b *layerBlob) Open() (io.ReadCloser, error) {
+	if b.empty {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	rc, err := b.pkg.GetLayer(b.diffID)
+	if err != nil {
+		return nil, fmt.Errorf("extracting buildpack %q layer (diffID %q): %w", b.desc.FullName(), b.diffID, err)
+	}
+
+	return rc, nil
+}
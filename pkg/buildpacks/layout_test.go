@@ -0,0 +1,186 @@
+package buildpacks_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/buildpacks"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/lifecycle/api"
+	"github.com/buildpacks/pack/pkg/archive"
+	"github.com/buildpacks/pack/pkg/buildpack"
+	"github.com/buildpacks/pack/pkg/dist"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func buildpackLayer(id, version string) (v1.Layer, error) {
+	tarBuilder := archive.TarBuilder{}
+	bpDir := fmt.Sprintf("%s/%s/%s", dist.BuildpacksDir, id, version)
+	tarBuilder.AddDir(bpDir, 0o755, time.Now())
+	tarBuilder.AddFile(bpDir+"/buildpack.toml", 0o644, time.Now(), []byte(fmt.Sprintf("[buildpack]\nid = %q\n", id)))
+	tarBuilder.AddFile(bpDir+"/bin/detect", 0o755, time.Now(), []byte("detect-contents"))
+
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return tarBuilder.Reader(archive.DefaultTarWriterFactory()), nil
+	})
+}
+
+func buildpackageImage(main string, deps ...string) v1.Image {
+	bpLayers := dist.ModuleLayers{}
+	layers := []v1.Layer{}
+	for _, id := range append([]string{main}, deps...) {
+		layer, err := buildpackLayer(id, "1.0.0")
+		Expect(err).NotTo(HaveOccurred())
+		diffID, err := layer.DiffID()
+		Expect(err).NotTo(HaveOccurred())
+
+		layers = append(layers, layer)
+		bpLayers[id] = map[string]dist.ModuleLayerInfo{
+			"1.0.0": {API: api.MustParse("0.10"), LayerDiffID: diffID.String()},
+		}
+	}
+
+	return withBuildpackLabels(layers, bpLayers, main)
+}
+
+// flattenedBuildpackageImage stores all buildpacks in one layer, as `pack buildpack package --flatten` does
+func flattenedBuildpackageImage(main string, deps ...string) v1.Image {
+	tarBuilder := archive.TarBuilder{}
+	for _, id := range append([]string{main}, deps...) {
+		bpDir := fmt.Sprintf("%s/%s/1.0.0", dist.BuildpacksDir, id)
+		tarBuilder.AddDir(bpDir, 0o755, time.Now())
+		tarBuilder.AddFile(bpDir+"/buildpack.toml", 0o644, time.Now(), []byte(fmt.Sprintf("[buildpack]\nid = %q\n", id)))
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return tarBuilder.Reader(archive.DefaultTarWriterFactory()), nil
+	})
+	Expect(err).NotTo(HaveOccurred())
+	diffID, err := layer.DiffID()
+	Expect(err).NotTo(HaveOccurred())
+
+	bpLayers := dist.ModuleLayers{}
+	for _, id := range append([]string{main}, deps...) {
+		bpLayers[id] = map[string]dist.ModuleLayerInfo{
+			"1.0.0": {API: api.MustParse("0.10"), LayerDiffID: diffID.String()},
+		}
+	}
+
+	return withBuildpackLabels([]v1.Layer{layer}, bpLayers, main)
+}
+
+func withBuildpackLabels(layers []v1.Layer, bpLayers dist.ModuleLayers, main string) v1.Image {
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	Expect(err).NotTo(HaveOccurred())
+
+	layersLabel, err := json.Marshal(bpLayers)
+	Expect(err).NotTo(HaveOccurred())
+	metadataLabel, err := json.Marshal(buildpack.Metadata{ModuleInfo: dist.ModuleInfo{ID: main, Version: "1.0.0"}})
+	Expect(err).NotTo(HaveOccurred())
+
+	img, err = mutate.Config(img, v1.Config{
+		Labels: map[string]string{
+			dist.BuildpackLayersLabel: string(layersLabel),
+			buildpack.MetadataLabel:   string(metadataLabel),
+		},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	return img
+}
+
+var _ = Describe("DownloadBuildpacks from OCI layouts", func() {
+	var err error
+	var logger = log.NewLogger()
+	var orderFile *os.File
+	var buildpacksDir string
+	var layoutDir string
+
+	BeforeEach(func() {
+		orderFile, err = os.CreateTemp("", "orderToml")
+		Expect(err).NotTo(HaveOccurred())
+		buildpacksDir, err = os.MkdirTemp("", "buildpackDir")
+		Expect(err).NotTo(HaveOccurred())
+		layoutDir, err = os.MkdirTemp("", "layout")
+		Expect(err).NotTo(HaveOccurred())
+
+		path, err := layout.Write(layoutDir, empty.Index)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path.AppendImage(buildpackageImage("composite", "dep1", "dep2"), layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": "1.0.0",
+		}))).To(Succeed())
+		Expect(path.AppendImage(buildpackageImage("other"), layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": "2.0.0",
+		}))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(orderFile.Name())).To(Succeed())
+		Expect(os.RemoveAll(buildpacksDir)).To(Succeed())
+		Expect(os.RemoveAll(layoutDir)).To(Succeed())
+	})
+
+	It("extracts the first image and its dependencies when no tag is given", func() {
+		err = buildpacks.DownloadBuildpacks([]string{buildpacks.OCILayoutPrefix + layoutDir}, buildpacksDir, nil, nil, orderFile, false, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		orderToml := buildpacks.OrderTOML{}
+		_, err = toml.DecodeFile(orderFile.Name(), &orderToml)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(orderToml.Order).To(HaveLen(1))
+		Expect(orderToml.Order[0].Group).To(HaveLen(1))
+		Expect(orderToml.Order[0].Group[0].ID).To(Equal("composite"))
+
+		for _, id := range []string{"composite", "dep1", "dep2"} {
+			Expect(filepath.Join(buildpacksDir, id, "1.0.0", "buildpack.toml")).To(BeAnExistingFile())
+		}
+		Expect(filepath.Join(buildpacksDir, "other")).NotTo(BeADirectory())
+	})
+
+	It("extracts the buildpacks of a flattened buildpackage", func() {
+		path, err := layout.FromPath(layoutDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(path.AppendImage(flattenedBuildpackageImage("flattened", "flat-dep1", "flat-dep2"), layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": "3.0.0",
+		}))).To(Succeed())
+
+		err = buildpacks.DownloadBuildpacks([]string{buildpacks.OCILayoutPrefix + layoutDir + ":3.0.0"}, buildpacksDir, nil, nil, orderFile, false, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		orderToml := buildpacks.OrderTOML{}
+		_, err = toml.DecodeFile(orderFile.Name(), &orderToml)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(orderToml.Order[0].Group[0].ID).To(Equal("flattened"))
+		for _, id := range []string{"flattened", "flat-dep1", "flat-dep2"} {
+			Expect(filepath.Join(buildpacksDir, id, "1.0.0", "buildpack.toml")).To(BeAnExistingFile())
+		}
+	})
+
+	It("selects the image by tag", func() {
+		err = buildpacks.DownloadBuildpacks([]string{buildpacks.OCILayoutPrefix + layoutDir + ":2.0.0"}, buildpacksDir, nil, nil, orderFile, false, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(filepath.Join(buildpacksDir, "other", "1.0.0", "bin", "detect")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildpacksDir, "composite")).NotTo(BeADirectory())
+	})
+
+	It("fails for an unknown tag", func() {
+		err = buildpacks.DownloadBuildpacks([]string{buildpacks.OCILayoutPrefix + layoutDir + ":4.0.0"}, buildpacksDir, nil, nil, orderFile, false, logger)
+		Expect(err).To(MatchError(ContainSubstring(`no image tagged "4.0.0" found`)))
+	})
+
+	It("fails when the layout does not exist", func() {
+		err = buildpacks.DownloadBuildpacks([]string{buildpacks.OCILayoutPrefix + filepath.Join(layoutDir, "missing")}, buildpacksDir, nil, nil, orderFile, false, logger)
+		Expect(err).To(MatchError(ContainSubstring("reading OCI layout")))
+	})
+})
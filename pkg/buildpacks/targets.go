@@ -0,0 +1,144 @@
+package buildpacks
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/lifecycle/buildpack"
+	"github.com/buildpacks/lifecycle/launch"
+	"github.com/buildpacks/lifecycle/phase"
+	"github.com/buildpacks/lifecycle/platform"
+	"github.com/buildpacks/lifecycle/platform/files"
+)
+
+// osDetector reads the distro from /etc/os-release when the target has none, like the detector of the lifecycle
+var osDetector = newOf((&phase.Detector{}).OSDetector)
+
+// CheckTargets verifies that the buildpacks required by the order in orderPath support the target. Composite
+// buildpacks are skipped as their targets are defined by the buildpacks they reference, buildpacks that are optional
+// or one of several groups are left to detection. With auto-detection incompatible buildpacks only cause a warning,
+// detection skips them.
+func CheckTargets(buildpacksDir, orderPath string, target files.TargetMetadata, autoDetect bool, logger *log.Logger) error {
+	orderToml := OrderTOML{}
+	if _, err := toml.DecodeFile(orderPath, &orderToml); err != nil {
+		return fmt.Errorf("failed to read %q: %w", orderPath, err)
+	}
+
+	descriptors := map[string]*buildpack.BpDescriptor{}
+	incompatible := []string{}
+	var check func(order buildpack.Order) error
+	check = func(order buildpack.Order) error {
+		if len(order) != 1 {
+			return nil
+		}
+
+		for _, bp := range order[0].Group {
+			if bp.Optional || descriptors[bp.String()] != nil {
+				continue
+			}
+
+			path := filepath.Join(buildpacksDir, launch.EscapeID(bp.ID), bp.Version, "buildpack.toml")
+			descriptor, err := buildpack.ReadBpDescriptor(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %q: %w", path, err)
+			}
+			descriptors[bp.String()] = descriptor
+
+			if len(descriptor.Order) > 0 {
+				if err := check(descriptor.Order); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if len(descriptor.Targets) == 0 || supportsTarget(descriptor.Targets, target, logger) {
+				continue
+			}
+
+			supported := []string{}
+			for _, t := range descriptor.Targets {
+				supported = append(supported, formatBuildpackTarget(t))
+			}
+			incompatible = append(incompatible, fmt.Sprintf("buildpack %q supports %s", descriptor.Buildpack.ID+"@"+descriptor.Buildpack.Version, strings.Join(supported, ", ")))
+		}
+
+		return nil
+	}
+
+	if autoDetect {
+		// every buildpack is a group of its own, check them as if they were required
+		for _, group := range orderToml.Order {
+			if err := check(buildpack.Order{group}); err != nil {
+				return err
+			}
+		}
+	} else if err := check(orderToml.Order); err != nil {
+		return err
+	}
+
+	if len(incompatible) > 0 && autoDetect {
+		logger.Warnf("Skipping buildpacks incompatible with target %s: %s", FormatTarget(target), strings.Join(incompatible, "; "))
+		return nil
+	}
+
+	if len(incompatible) > 0 {
+		return fmt.Errorf("buildpacks incompatible with target %s: %s", FormatTarget(target), strings.Join(incompatible, "; "))
+	}
+
+	return nil
+}
+
+func supportsTarget(targets []buildpack.TargetMetadata, target files.TargetMetadata, logger *log.Logger) bool {
+	for _, t := range targets {
+		base := target
+		if platform.TargetSatisfiedForBuild(osDetector, &base, t, logger) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newOf returns a new value of the type p points to, the lifecycle does not export the type of its OS detector
+func newOf[T any](_ *T) *T {
+	return new(T)
+}
+
+// FormatTarget renders a target as "os/arch[/variant] [distro@version]"
+func FormatTarget(target files.TargetMetadata) string {
+	s := platformString(target.OS, target.Arch, target.ArchVariant)
+	if target.Distro != nil {
+		s += fmt.Sprintf(" %s@%s", target.Distro.Name, target.Distro.Version)
+	}
+
+	return s
+}
+
+func formatBuildpackTarget(target buildpack.TargetMetadata) string {
+	s := platformString(target.OS, target.Arch, target.ArchVariant)
+
+	distros := []string{}
+	for _, d := range target.Distros {
+		distros = append(distros, fmt.Sprintf("%s@%s", d.Name, d.Version))
+	}
+	if len(distros) > 0 {
+		s += fmt.Sprintf(" (%s)", strings.Join(distros, ", "))
+	}
+
+	return s
+}
+
+func platformString(targetOS, arch, variant string) string {
+	parts := []string{}
+	for _, p := range []string{targetOS, arch, variant} {
+		if p == "" {
+			p = "*"
+		}
+		parts = append(parts, p)
+	}
+
+	return strings.TrimSuffix(strings.Join(parts, "/"), "/*")
+}
@@ -0,0 +1,192 @@
+package buildpacks_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/buildpacks"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
+	"github.com/buildpacks/lifecycle/platform/files"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CheckTargets", func() {
+	var buildpacksDir string
+	var orderPath string
+	var target files.TargetMetadata
+	var logger = log.NewLogger()
+
+	writeOrder := func(ids ...string) {
+		group := ""
+		for _, id := range ids {
+			group += fmt.Sprintf("[[order.group]]\nid = %q\nversion = \"1.0.0\"\n", id)
+		}
+		Expect(os.WriteFile(orderPath, []byte("[[order]]\n"+group), 0o644)).To(Succeed())
+	}
+
+	writeDescriptor := func(id, version, content string) {
+		dir := filepath.Join(buildpacksDir, id, version)
+		Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "buildpack.toml"), []byte(content), 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildpacksDir = GinkgoT().TempDir()
+		orderPath = filepath.Join(GinkgoT().TempDir(), "order.toml")
+		writeOrder("any", "noble", "composite")
+		target = files.TargetMetadata{
+			OS:     "linux",
+			Arch:   "amd64",
+			Distro: &files.OSDistro{Name: "ubuntu", Version: "24.04"},
+		}
+
+		writeDescriptor("any", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "any"
+version = "1.0.0"
+`)
+		writeDescriptor("noble", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "noble"
+version = "1.0.0"
+
+[[targets]]
+os = "linux"
+arch = "amd64"
+[[targets.distros]]
+name = "ubuntu"
+version = "24.04"
+`)
+		writeDescriptor("composite", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "composite"
+version = "1.0.0"
+[[order]]
+[[order.group]]
+id = "noble"
+version = "1.0.0"
+`)
+	})
+
+	It("accepts compatible buildpacks", func() {
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(Succeed())
+	})
+
+	It("accepts buildpacks without distros for any distro", func() {
+		target.Distro = &files.OSDistro{Name: "ubuntu", Version: "22.04"}
+		writeDescriptor("linux", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "linux"
+version = "1.0.0"
+[[targets]]
+os = "linux"
+`)
+		writeOrder("linux")
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(Succeed())
+	})
+
+	It("names incompatible buildpacks and their supported targets", func() {
+		writeDescriptor("jammy", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "jammy"
+version = "1.0.0"
+
+[[targets]]
+os = "linux"
+arch = "amd64"
+[[targets.distros]]
+name = "ubuntu"
+version = "22.04"
+
+[[targets]]
+os = "linux"
+arch = "arm64"
+`)
+		writeOrder("any", "noble", "jammy")
+
+		err := buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)
+		Expect(err).To(MatchError(`buildpacks incompatible with target linux/amd64 ubuntu@24.04: buildpack "jammy@1.0.0" supports linux/amd64 (ubuntu@22.04), linux/arm64`))
+	})
+
+	It("checks buildpacks referenced by composite buildpacks", func() {
+		target.Distro = &files.OSDistro{Name: "ubuntu", Version: "22.04"}
+		writeOrder("composite")
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(MatchError(ContainSubstring(`buildpack "noble@1.0.0" supports linux/amd64 (ubuntu@24.04)`)))
+	})
+
+	It("leaves optional buildpacks to detection", func() {
+		target.Distro = &files.OSDistro{Name: "ubuntu", Version: "22.04"}
+		writeDescriptor("optional", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "optional"
+version = "1.0.0"
+[[order]]
+[[order.group]]
+id = "noble"
+version = "1.0.0"
+optional = true
+[[order.group]]
+id = "any"
+version = "1.0.0"
+`)
+		writeOrder("optional")
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(Succeed())
+	})
+
+	It("leaves buildpacks of alternative groups to detection", func() {
+		target.Distro = &files.OSDistro{Name: "ubuntu", Version: "22.04"}
+		writeDescriptor("alternatives", "1.0.0", `
+api = "0.10"
+[buildpack]
+id = "alternatives"
+version = "1.0.0"
+[[order]]
+[[order.group]]
+id = "noble"
+version = "1.0.0"
+[[order]]
+[[order.group]]
+id = "any"
+version = "1.0.0"
+`)
+		writeOrder("alternatives")
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(Succeed())
+	})
+
+	It("reads the distro from /etc/os-release when it is unknown", func() {
+		host, err := staging.DetectTarget(staging.OSReleasePath)
+		if err != nil || host.Distro == nil {
+			Skip("no distro in " + staging.OSReleasePath)
+		}
+
+		target.Distro = nil
+		writeDescriptor("host", "1.0.0", fmt.Sprintf(`
+api = "0.10"
+[buildpack]
+id = "host"
+version = "1.0.0"
+
+[[targets]]
+os = "linux"
+[[targets.distros]]
+name = %q
+version = %q
+`, host.Distro.Name, host.Distro.Version))
+		writeOrder("host")
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, false, logger)).To(Succeed())
+	})
+
+	It("skips incompatible buildpacks with auto-detection", func() {
+		target.Distro = &files.OSDistro{Name: "ubuntu", Version: "22.04"}
+		Expect(buildpacks.CheckTargets(buildpacksDir, orderPath, target, true, logger)).To(Succeed())
+	})
+})
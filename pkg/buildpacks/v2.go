@@ -0,0 +1,337 @@
+package buildpacks
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/lifecycle/api"
+	lifecycle "github.com/buildpacks/lifecycle/buildpack"
+	"github.com/buildpacks/lifecycle/launch"
+	"github.com/buildpacks/pack/pkg/blob"
+	"github.com/buildpacks/pack/pkg/buildpack"
+	"github.com/buildpacks/pack/pkg/dist"
+)
+
+const (
+	V2BuildpackIDPrefix = "cf-v2/"
+	v2BuildpackAPI      = "0.10"
+	v2DefaultVersion    = "0.0.0"
+	v2GroupFile         = "group.env"
+)
+
+var (
+	//go:embed v2shim
+	v2Shims embed.FS
+
+	invalidIDChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+	zipMagic       = []byte("PK\x03\x04")
+)
+
+// WriteV2GroupPositions records the position of the v2 buildpacks among the v2 buildpacks of the detected group and
+// the deps dir they share for their build shims, the position is passed to bin/supply as deps index and only the last
+// v2 buildpack runs bin/finalize and bin/release
+func WriteV2GroupPositions(buildpacksDir, depsDir string, group []lifecycle.GroupElement) error {
+	var v2Group []lifecycle.GroupElement
+	for _, bp := range group {
+		if strings.HasPrefix(bp.ID, V2BuildpackIDPrefix) {
+			v2Group = append(v2Group, bp)
+		}
+	}
+
+	if len(v2Group) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(depsDir, 0o755); err != nil {
+		return fmt.Errorf("creating deps dir: %w", err)
+	}
+
+	for i, bp := range v2Group {
+		content := fmt.Sprintf("deps_dir=%q\ndeps_idx=\"%d\"\nfinal=\"%t\"\n", depsDir, i, i == len(v2Group)-1)
+		path := filepath.Join(buildpacksDir, launch.EscapeID(bp.ID), bp.Version, v2GroupFile)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return fmt.Errorf("writing group position of %s: %w", bp.String(), err)
+		}
+	}
+
+	return nil
+}
+
+type v2Shim struct {
+	AutoDetect bool
+}
+
+// isV2Buildpack reports whether the blob contains a classic Cloud Foundry buildpack, one with bin/detect and
+// bin/supply, bin/finalize or bin/compile but without a buildpack.toml
+func isV2Buildpack(b blob.Blob) (bool, error) {
+	entries := map[string]bool{}
+	if err := walkArchive(b, func(hdr *tar.Header, _ io.Reader) error {
+		entries[cleanEntryName(hdr.Name)] = true
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	return !entries["buildpack.toml"] && entries["bin/detect"] &&
+		(entries["bin/supply"] || entries["bin/finalize"] || entries["bin/compile"]), nil
+}
+
+// shimV2Buildpack wraps a v2 buildpack into a CNB buildpack, the original contents are placed in the "v2"
+// directory and driven by generated bin/detect and bin/build scripts
+func shimV2Buildpack(locator string, b blob.Blob, autoDetect bool) (buildpack.BuildModule, error) {
+	language, version := "", ""
+	if err := walkArchive(b, func(hdr *tar.Header, r io.Reader) error {
+		switch cleanEntryName(hdr.Name) {
+		case "manifest.yml":
+			language = readManifestLanguage(r)
+		case "VERSION":
+			content, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			version = strings.TrimSpace(string(content))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if language == "" {
+		language = locatorName(locator)
+	}
+	if version == "" {
+		version = v2DefaultVersion
+	}
+
+	desc := dist.BuildpackDescriptor{
+		WithAPI: api.MustParse(v2BuildpackAPI),
+		WithInfo: dist.ModuleInfo{
+			ID:      V2BuildpackIDPrefix + invalidIDChars.ReplaceAllString(strings.ToLower(language), "-"),
+			Version: version,
+			Name:    fmt.Sprintf("%s (Cloud Foundry v2 buildpack)", language),
+		},
+	}
+
+	return buildpack.FromBlob(&desc, &v2ShimBlob{
+		source:     b,
+		descriptor: desc,
+		shim:       v2Shim{AutoDetect: autoDetect},
+	}), nil
+}
+
+type v2ShimBlob struct {
+	source     blob.Blob
+	descriptor dist.BuildpackDescriptor
+	shim       v2Shim
+}
+
+func (s *v2ShimBlob) Open() (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		pw.CloseWithError(s.write(tar.NewWriter(pw)))
+	}()
+
+	return pr, nil
+}
+
+func (s *v2ShimBlob) write(tw *tar.Writer) error {
+	baseDir := path.Join(dist.BuildpacksDir, s.descriptor.EscapedID(), s.descriptor.Info().Version)
+	now := time.Now()
+
+	for _, dir := range []string{baseDir, path.Join(baseDir, "bin"), path.Join(baseDir, "v2")} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0o755, ModTime: now}); err != nil {
+			return err
+		}
+	}
+
+	descriptor := &bytes.Buffer{}
+	if err := toml.NewEncoder(descriptor).Encode(s.descriptor); err != nil {
+		return err
+	}
+	if err := writeFile(tw, path.Join(baseDir, "buildpack.toml"), 0o644, now, descriptor.Bytes()); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"detect", "build"} {
+		script, err := s.renderShim(name)
+		if err != nil {
+			return err
+		}
+
+		if err := writeFile(tw, path.Join(baseDir, "bin", name), 0o755, now, script); err != nil {
+			return err
+		}
+	}
+
+	if err := walkArchive(s.source, func(hdr *tar.Header, r io.Reader) error {
+		name := cleanEntryName(hdr.Name)
+		if name == "" {
+			return nil
+		}
+
+		hdr.Name = path.Join(baseDir, "v2", name)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		_, err := io.Copy(tw, r)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func (s *v2ShimBlob) renderShim(name string) ([]byte, error) {
+	tmpl, err := template.ParseFS(v2Shims, path.Join("v2shim", name))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, s.shim); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeFile(tw *tar.Writer, name string, mode int64, modTime time.Time, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     int64(len(content)),
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(content)
+	return err
+}
+
+// walkArchive calls fn for every entry of a tar or zip blob
+func walkArchive(b blob.Blob, fn func(hdr *tar.Header, r io.Reader) error) error {
+	rc, err := b.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if bytes.Equal(magic, zipMagic) {
+		return walkZip(br, fn)
+	}
+
+	tr := tar.NewReader(br)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := os.CreateTemp("", "v2-buildpack-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		if err := walkZipEntry(zf, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkZipEntry(zf *zip.File, fn func(hdr *tar.Header, r io.Reader) error) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	hdr, err := tar.FileInfoHeader(zf.FileInfo(), "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = zf.Name
+
+	if zf.Mode()&os.ModeSymlink != 0 {
+		link, err := io.ReadAll(rc)
+		if err != nil {
+			return err
+		}
+		hdr.Linkname = string(link)
+		return fn(hdr, bytes.NewReader(nil))
+	}
+
+	return fn(hdr, rc)
+}
+
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func readManifestLanguage(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "language:"); ok {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+
+	return ""
+}
+
+func locatorName(locator string) string {
+	name := path.Base(strings.SplitN(locator, "?", 2)[0])
+	for _, ext := range []string{".zip", ".tgz", ".tar.gz", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	return name
+}
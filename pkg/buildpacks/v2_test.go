@@ -0,0 +1,240 @@
+package buildpacks_test
+
+import (
+	"archive/zip"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/buildpacks"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/BurntSushi/toml"
+	lifecycle "github.com/buildpacks/lifecycle/buildpack"
+	"github.com/buildpacks/pack/pkg/blob"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func v2Files(language string) map[string]string {
+	return map[string]string{
+		"manifest.yml": "---\nlanguage: " + language + "\n",
+		"VERSION":      "1.2.3\n",
+		"bin/detect":   "#!/usr/bin/env bash\n[[ -f \"$1/Gemfile\" ]]\n",
+		"bin/supply":   "#!/usr/bin/env bash\nmkdir -p \"$3/$4/bin\"\necho " + language + " > \"$3/$4/bin/" + language + "\"\n",
+		"bin/finalize": "#!/usr/bin/env bash\n" + `echo "export FINALIZED='$(cat "$3"/*/bin/* | xargs)'" > "$5/finalize.sh"` + "\n",
+		"bin/release":  "#!/usr/bin/env bash\necho '---'\necho 'default_process_types:'\necho '  web: bundle exec rackup -p \"$PORT\"'\n",
+	}
+}
+
+func writeV2Dir(dir, language string) {
+	for name, content := range v2Files(language) {
+		Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755)).To(Succeed())
+	}
+}
+
+func writeV2Zip(path string) {
+	f, err := os.Create(path)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range v2Files("ruby") {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(0o755)
+		w, err := zw.CreateHeader(header)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = w.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	Expect(zw.Close()).To(Succeed())
+}
+
+var _ = Describe("DownloadBuildpacks with v2 buildpacks", func() {
+	var err error
+	var logger = log.NewLogger()
+	var orderFile *os.File
+	var buildpacksDir string
+	var sourceDir string
+	var downloader blob.Downloader
+
+	BeforeEach(func() {
+		orderFile, err = os.CreateTemp("", "orderToml")
+		Expect(err).NotTo(HaveOccurred())
+		buildpacksDir, err = os.MkdirTemp("", "buildpackDir")
+		Expect(err).NotTo(HaveOccurred())
+		sourceDir, err = os.MkdirTemp("", "v2")
+		Expect(err).NotTo(HaveOccurred())
+		downloader = blob.NewDownloader(logger, filepath.Join(sourceDir, "cache"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(orderFile.Name())).To(Succeed())
+		Expect(os.RemoveAll(buildpacksDir)).To(Succeed())
+		Expect(os.RemoveAll(sourceDir)).To(Succeed())
+	})
+
+	expectShim := func() string {
+		orderToml := buildpacks.OrderTOML{}
+		_, err = toml.DecodeFile(orderFile.Name(), &orderToml)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(orderToml.Order).To(HaveLen(1))
+		Expect(orderToml.Order[0].Group[0].ID).To(Equal("cf-v2/ruby"))
+		Expect(orderToml.Order[0].Group[0].Version).To(Equal("1.2.3"))
+
+		bpDir := filepath.Join(buildpacksDir, "cf-v2_ruby", "1.2.3")
+		Expect(filepath.Join(bpDir, "buildpack.toml")).To(BeAnExistingFile())
+		Expect(filepath.Join(bpDir, "bin", "detect")).To(BeAnExistingFile())
+		Expect(filepath.Join(bpDir, "bin", "build")).To(BeAnExistingFile())
+		Expect(filepath.Join(bpDir, "v2", "bin", "supply")).To(BeAnExistingFile())
+
+		return bpDir
+	}
+
+	It("wraps a v2 buildpack directory", func() {
+		writeV2Dir(filepath.Join(sourceDir, "ruby"), "ruby")
+
+		err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby")}, buildpacksDir, nil, downloader, orderFile, false, logger)
+		Expect(err).NotTo(HaveOccurred())
+		expectShim()
+	})
+
+	It("wraps a zipped v2 buildpack", func() {
+		writeV2Zip(filepath.Join(sourceDir, "ruby_buildpack.zip"))
+
+		err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby_buildpack.zip")}, buildpacksDir, nil, downloader, orderFile, false, logger)
+		Expect(err).NotTo(HaveOccurred())
+		expectShim()
+	})
+
+	Context("running the shims", func() {
+		var bpDir, appDir, layersDir string
+
+		BeforeEach(func() {
+			writeV2Dir(filepath.Join(sourceDir, "ruby"), "ruby")
+			appDir = filepath.Join(sourceDir, "app")
+			layersDir = filepath.Join(sourceDir, "layers")
+			Expect(os.MkdirAll(appDir, 0o755)).To(Succeed())
+			Expect(os.MkdirAll(layersDir, 0o755)).To(Succeed())
+		})
+
+		runIn := func(bpDir, layersDir, script string) error {
+			cmd := exec.Command(filepath.Join(bpDir, "bin", script))
+			cmd.Dir = appDir
+			cmd.Env = append(os.Environ(), "CNB_LAYERS_DIR="+layersDir)
+			cmd.Stdout = GinkgoWriter
+			cmd.Stderr = GinkgoWriter
+			return cmd.Run()
+		}
+
+		run := func(script string, args ...string) error {
+			cmd := exec.Command(filepath.Join(bpDir, "bin", script), args...)
+			cmd.Dir = appDir
+			cmd.Env = append(os.Environ(), "CNB_LAYERS_DIR="+layersDir)
+			cmd.Stdout = GinkgoWriter
+			cmd.Stderr = GinkgoWriter
+			return cmd.Run()
+		}
+
+		It("skips detection when buildpacks are requested explicitly", func() {
+			err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby")}, buildpacksDir, nil, downloader, orderFile, false, logger)
+			Expect(err).NotTo(HaveOccurred())
+			bpDir = expectShim()
+
+			Expect(run("detect")).To(Succeed())
+		})
+
+		It("runs bin/detect when auto-detecting", func() {
+			err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby")}, buildpacksDir, nil, downloader, orderFile, true, logger)
+			Expect(err).NotTo(HaveOccurred())
+			bpDir = expectShim()
+
+			err = run("detect")
+			Expect(err).To(BeAssignableToTypeOf(&exec.ExitError{}))
+			Expect(err.(*exec.ExitError).ExitCode()).To(Equal(100))
+
+			Expect(os.WriteFile(filepath.Join(appDir, "Gemfile"), nil, 0o644)).To(Succeed())
+			Expect(run("detect")).To(Succeed())
+		})
+
+		It("maps supply, finalize and release onto layers and processes", func() {
+			err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby")}, buildpacksDir, nil, downloader, orderFile, false, logger)
+			Expect(err).NotTo(HaveOccurred())
+			bpDir = expectShim()
+
+			Expect(run("build", layersDir)).To(Succeed())
+
+			Expect(filepath.Join(layersDir, "deps.toml")).To(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, "deps", "bin", "ruby")).To(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, "deps", "profile.d", "finalize.sh")).To(BeAnExistingFile())
+
+			launch := struct {
+				Processes []struct {
+					Type    string   `toml:"type"`
+					Command []string `toml:"command"`
+					Default bool     `toml:"default"`
+				} `toml:"processes"`
+			}{}
+			_, err = toml.DecodeFile(filepath.Join(layersDir, "launch.toml"), &launch)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(launch.Processes).To(HaveLen(1))
+			Expect(launch.Processes[0].Type).To(Equal("web"))
+			Expect(launch.Processes[0].Command).To(Equal([]string{"bash", "-c", `bundle exec rackup -p "$PORT"`}))
+			Expect(launch.Processes[0].Default).To(BeTrue())
+		})
+
+		It("counts only v2 buildpacks for the deps index and the last buildpack", func() {
+			err = buildpacks.DownloadBuildpacks([]string{"file://" + filepath.Join(sourceDir, "ruby")}, buildpacksDir, nil, downloader, orderFile, false, logger)
+			Expect(err).NotTo(HaveOccurred())
+			bpDir = expectShim()
+
+			depsDir := filepath.Join(sourceDir, "deps")
+			Expect(buildpacks.WriteV2GroupPositions(buildpacksDir, depsDir, []lifecycle.GroupElement{
+				{ID: "example/other", Version: "1.0.0"},
+				{ID: "cf-v2/ruby", Version: "1.2.3"},
+				{ID: "example/procfile", Version: "1.0.0"},
+			})).To(Succeed())
+
+			Expect(run("build", layersDir)).To(Succeed())
+
+			Expect(filepath.Join(depsDir, "0", "bin", "ruby")).To(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, "deps", "bin", "ruby")).To(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, "deps", "profile.d", "finalize.sh")).To(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, "launch.toml")).To(BeAnExistingFile())
+		})
+
+		It("shares the deps dir between v2 buildpacks and finalizes with the last one", func() {
+			writeV2Dir(filepath.Join(sourceDir, "node"), "node")
+			err = buildpacks.DownloadBuildpacks([]string{
+				"file://" + filepath.Join(sourceDir, "ruby"),
+				"file://" + filepath.Join(sourceDir, "node"),
+			}, buildpacksDir, nil, downloader, orderFile, false, logger)
+			Expect(err).NotTo(HaveOccurred())
+			rubyDir := expectShim()
+			nodeDir := filepath.Join(buildpacksDir, "cf-v2_node", "1.2.3")
+
+			depsDir := filepath.Join(sourceDir, "deps")
+			Expect(buildpacks.WriteV2GroupPositions(buildpacksDir, depsDir, []lifecycle.GroupElement{
+				{ID: "cf-v2/ruby", Version: "1.2.3"},
+				{ID: "cf-v2/node", Version: "1.2.3"},
+				{ID: "example/procfile", Version: "1.0.0"},
+			})).To(Succeed())
+
+			rubyLayers := filepath.Join(layersDir, "ruby")
+			nodeLayers := filepath.Join(layersDir, "node")
+			Expect(runIn(rubyDir, rubyLayers, "build")).To(Succeed())
+			Expect(runIn(nodeDir, nodeLayers, "build")).To(Succeed())
+
+			Expect(filepath.Join(depsDir, "0", "bin", "ruby")).To(BeAnExistingFile())
+			Expect(filepath.Join(depsDir, "1", "bin", "node")).To(BeAnExistingFile())
+			Expect(filepath.Join(rubyLayers, "deps", "bin", "ruby")).To(BeAnExistingFile())
+			Expect(filepath.Join(nodeLayers, "deps", "bin", "node")).To(BeAnExistingFile())
+			Expect(os.ReadFile(filepath.Join(nodeLayers, "deps", "env", "DEPS_DIR.override"))).To(BeEquivalentTo(depsDir))
+
+			Expect(filepath.Join(rubyLayers, "deps", "profile.d", "finalize.sh")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(rubyLayers, "launch.toml")).NotTo(BeAnExistingFile())
+			Expect(os.ReadFile(filepath.Join(nodeLayers, "deps", "profile.d", "finalize.sh"))).To(ContainSubstring("FINALIZED='ruby node'"))
+			Expect(filepath.Join(nodeLayers, "launch.toml")).To(BeAnExistingFile())
+		})
+	})
+})
@@ -3,60 +3,92 @@ package credhub
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
 	api "code.cloudfoundry.org/credhub-cli/credhub"
+	"code.cloudfoundry.org/credhub-cli/credhub/auth"
 )
 
 type platformOptions struct {
 	CredhubURI string `json:"credhub-uri"`
+	// SecretResolver selects the resolver for credhub references, defaults to "credhub" when CredhubURI is set
+	SecretResolver    string `json:"secret-resolver"`
+	SecretDir         string `json:"secret-dir"`
+	SecretResolverURI string `json:"secret-resolver-uri"`
+	// CredhubAuth selects how to authenticate with CredHub, see credhubAuth
+	CredhubAuth    string `json:"credhub-auth"`
+	CredhubAuthURL string `json:"credhub-auth-url"`
+	// the credentials are only taken from the platform options, the env vars of the app are controlled by its
+	// developers
+	CredhubClient string `json:"credhub-client"`
+	CredhubSecret string `json:"credhub-secret"`
+	CredhubToken  string `json:"credhub-token"`
 }
 
-func InterpolateServiceRefs(maxConnectionAttempts int, retryDelay time.Duration) error {
+const (
+	AuthMTLS  = "mtls"
+	AuthUAA   = "uaa"
+	AuthToken = "token"
+)
+
+func InterpolateServiceRefs(retry Retry, logger *log.Logger) error {
 	platformOptions, err := getPlatformOptions()
 	if err != nil {
 		return fmt.Errorf("unable to get platform options: %s", err)
 	}
 
-	if platformOptions.CredhubURI == "" {
+	if platformOptions.resolverName() == "" {
 		return nil
 	}
 
 	if os.Getenv("CREDHUB_SKIP_INTERPOLATION") != "" {
 		return nil
 	}
-	if !strings.Contains(os.Getenv("VCAP_SERVICES"), `"credhub-ref"`) {
+	services, err := vcap.ParseServices(os.Getenv("VCAP_SERVICES"), vcap.Strict)
+	if err != nil {
+		// the references of services that cannot be parsed would silently stay unresolved
+		if strings.Contains(os.Getenv("VCAP_SERVICES"), refKey) {
+			return fmt.Errorf("unable to find credhub references: %w", err)
+		}
 		return nil
 	}
-
-	ch, err := credhubClient(platformOptions.CredhubURI)
-	if err != nil {
-		return fmt.Errorf("unable to set up credhub client: %v", err)
+	if !services.HasCredHubRefs() {
+		return nil
 	}
 
-	var interpolatedServices string
-	for attempt := 1; attempt <= maxConnectionAttempts; attempt++ {
-		interpolatedServices, err = ch.InterpolateString(os.Getenv("VCAP_SERVICES"))
-		if err == nil {
-			break
-		}
-		fmt.Printf("Failed on attempt %v out of %v: Unable to interpolate credhub references: %v\n", attempt, maxConnectionAttempts, err)
-		time.Sleep(retryDelay)
+	resolver, err := newResolver(platformOptions, retry.Transport, logger)
+	if err != nil {
+		return err
 	}
 
+	interpolatedServices, err := interpolate(resolver, os.Getenv("VCAP_SERVICES"), retry, logger)
 	if err != nil {
-		return fmt.Errorf("unable to interpolate credhub references: %v", err)
+		return err
 	}
 
+	addServiceSecrets(services, interpolatedServices)
+
 	if err := os.Setenv("VCAP_SERVICES", interpolatedServices); err != nil {
 		return fmt.Errorf("unable to update VCAP_SERVICES with interpolated credhub references: %v", err)
 	}
 	return nil
 }
 
+// addServiceSecrets masks the interpolated credentials of the services that referenced credhub
+func addServiceSecrets(services vcap.Services, interpolatedServices string) {
+	interpolated, _ := vcap.ParseServices(interpolatedServices, vcap.Lenient)
+	for _, svc := range interpolated.All() {
+		if ref, ok := services.ByName(svc.Name); ok && ref.HasCredHubRef() {
+			log.AddSecretValues(svc.Credentials)
+		}
+	}
+}
+
 func getPlatformOptions() (platformOptions, error) {
 	var platformOptions platformOptions
 	platformOptionString := os.Getenv("VCAP_PLATFORM_OPTIONS")
@@ -68,11 +100,77 @@ func getPlatformOptions() (platformOptions, error) {
 	if err != nil {
 		return platformOptions, err
 	}
+	log.AddSecrets(platformOptions.CredhubSecret, platformOptions.CredhubToken)
 
 	return platformOptions, nil
 }
 
-func credhubClient(credhubURI string) (*api.CredHub, error) {
+func credhubClient(options platformOptions) (*api.CredHub, error) {
+	switch options.credhubAuth() {
+	case AuthMTLS:
+		return mtlsClient(options.CredhubURI)
+	case AuthUAA:
+		if options.CredhubClient == "" || options.CredhubSecret == "" {
+			return nil, fmt.Errorf("missing credhub-client and/or credhub-secret")
+		}
+		return tokenClient(options, api.Auth(auth.UaaClientCredentials(options.CredhubClient, options.CredhubSecret)))
+	case AuthToken:
+		if options.CredhubToken == "" {
+			return nil, fmt.Errorf("missing credhub-token")
+		}
+		return tokenClient(options, api.Auth(staticToken(options.CredhubToken)))
+	default:
+		return nil, fmt.Errorf("unknown credhub auth %q", options.CredhubAuth)
+	}
+}
+
+// credhubAuth returns the configured auth mode, UAA client credentials or a static token if the platform options
+// provide them and the instance identity otherwise
+func (o platformOptions) credhubAuth() string {
+	switch {
+	case o.CredhubAuth != "":
+		return o.CredhubAuth
+	case o.CredhubClient != "" && o.CredhubSecret != "":
+		return AuthUAA
+	case o.CredhubToken != "":
+		return AuthToken
+	}
+
+	return AuthMTLS
+}
+
+func tokenClient(options platformOptions, authOption api.Option) (*api.CredHub, error) {
+	caCerts, err := systemCerts(os.Getenv("CF_SYSTEM_CERT_PATH"))
+	if err != nil {
+		return nil, err
+	}
+
+	return api.New(
+		options.CredhubURI,
+		authOption,
+		api.AuthURL(options.CredhubAuthURL),
+		api.CaCerts(caCerts...),
+	)
+}
+
+// staticToken authenticates every request with a bearer token
+func staticToken(token string) auth.Builder {
+	return func(config auth.Config) (auth.Strategy, error) {
+		return &tokenStrategy{client: config.Client(), token: token}, nil
+	}
+}
+
+type tokenStrategy struct {
+	client *http.Client
+	token  string
+}
+
+func (s *tokenStrategy) Do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	return s.client.Do(req)
+}
+
+func mtlsClient(credhubURI string) (*api.CredHub, error) {
 	instanceCertPath := os.Getenv("CF_INSTANCE_CERT")
 	instanceKeyPath := os.Getenv("CF_INSTANCE_KEY")
 	systemCertsPath := os.Getenv("CF_SYSTEM_CERT_PATH")
@@ -84,7 +182,24 @@ func credhubClient(credhubURI string) (*api.CredHub, error) {
 		return nil, fmt.Errorf("missing CF_SYSTEM_CERT_PATH")
 	}
 
+	caCerts, err := systemCerts(systemCertsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.New(
+		credhubURI,
+		api.ClientCert(instanceCertPath, instanceKeyPath),
+		api.CaCerts(caCerts...),
+	)
+}
+
+func systemCerts(systemCertsPath string) ([]string, error) {
 	caCerts := []string{}
+	if systemCertsPath == "" {
+		return caCerts, nil
+	}
+
 	files, err := os.ReadDir(systemCertsPath)
 	if err != nil {
 		return nil, fmt.Errorf("can't read contents of system cert path: %v", err)
@@ -99,9 +214,5 @@ func credhubClient(credhubURI string) (*api.CredHub, error) {
 		}
 	}
 
-	return api.New(
-		credhubURI,
-		api.ClientCert(instanceCertPath, instanceKeyPath),
-		api.CaCerts(caCerts...),
-	)
+	return caCerts, nil
 }
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/credhub"
+	cnberrors "code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
@@ -77,7 +79,7 @@ var _ = Describe("credhub", func() {
 		})
 
 		JustBeforeEach(func() {
-			err = credhub.InterpolateServiceRefs(maxConnectAttempts, retryDelay)
+			err = credhub.InterpolateServiceRefs(credhub.Retry{Attempts: maxConnectAttempts, Delay: retryDelay}, log.NewLogger())
 		})
 
 		Context("when there are no credhub refs in VCAP_SERVICES and no TLS environment variables are present", func() {
@@ -96,6 +98,31 @@ var _ = Describe("credhub", func() {
 			})
 		})
 
+		Context("when VCAP_SERVICES with credhub refs cannot be parsed", func() {
+			BeforeEach(func() {
+				vcapServicesValue = `{"my-server":[{"tags":"not-a-list","credentials":{"credhub-ref":"(//my-server/creds)"}}]}`
+				GinkgoT().Setenv("VCAP_SERVICES", vcapServicesValue)
+			})
+
+			It("returns an error and doesn't change VCAP_SERVICES", func() {
+				Expect(err).To(MatchError(ContainSubstring("unable to find credhub references: failed to parse VCAP_SERVICES")))
+				Expect(os.Getenv("VCAP_SERVICES")).To(Equal(vcapServicesValue))
+				Expect(server.ReceivedRequests()).To(BeEmpty())
+			})
+		})
+
+		Context("when VCAP_SERVICES without credhub refs cannot be parsed", func() {
+			BeforeEach(func() {
+				vcapServicesValue = `{"my-server":[{"tags":"not-a-list","credentials":{}}]}`
+				GinkgoT().Setenv("VCAP_SERVICES", vcapServicesValue)
+			})
+
+			It("does not fail and does not change VCAP_SERVICES", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Getenv("VCAP_SERVICES")).To(Equal(vcapServicesValue))
+			})
+		})
+
 		Context("when credhub successfully interpolates", func() {
 			BeforeEach(func() {
 				server.AppendHandlers(
@@ -114,6 +141,25 @@ var _ = Describe("credhub", func() {
 
 		})
 
+		Context("when credhub interpolates credentials", func() {
+			BeforeEach(func() {
+				vcapServicesValue = `{"my-server":[{"name":"referenced","credentials":{"credhub-ref":"(//my-server/creds)"}},{"name":"plain","credentials":{"user":"plain-visible"}}]}`
+				GinkgoT().Setenv("VCAP_SERVICES", vcapServicesValue)
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/api/v1/interpolate"),
+						ghttp.RespondWith(http.StatusOK, `{"my-server":[{"name":"referenced","credentials":{"password":"interpolated-password","host":"interpolated-host","port":"15432"}},{"name":"plain","credentials":{"user":"plain-visible"}}]}`),
+					))
+			})
+
+			It("masks the interpolated credentials in the log output", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(log.Redact("interpolated-password plain-visible")).To(Equal("[REDACTED] plain-visible"))
+				Expect(log.Redact("interpolated-host:15432")).To(Equal("interpolated-host:15432"))
+			})
+		})
+
 		Context("when credhub fails initially, but eventually succeeds", func() {
 			BeforeEach(func() {
 
@@ -159,6 +205,22 @@ var _ = Describe("credhub", func() {
 			})
 		})
 
+		Context("when credhub denies access", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/api/v1/interpolate"),
+						ghttp.RespondWith(http.StatusForbidden, `{"error": "forbidden"}`),
+					))
+			})
+
+			It("returns a not found error without retrying", func() {
+				Expect(err).To(MatchError(cnberrors.ErrSecretNotFound))
+				Expect(server.ReceivedRequests()).To(HaveLen(1))
+				Expect(os.Getenv("VCAP_SERVICES")).To(Equal(vcapServicesValue))
+			})
+		})
+
 		Context("when the instance cert and key are invalid", func() {
 			BeforeEach(func() {
 				GinkgoT().Setenv("CF_INSTANCE_CERT", filepath.Join(certDir, "not_a_cert"))
@@ -252,4 +314,95 @@ var _ = Describe("credhub", func() {
 			})
 		})
 	})
+
+	Describe("authentication", func() {
+		var server *ghttp.Server
+		const vcapServicesValue = `{"my-server":[{"credentials":{"credhub-ref":"(//my-server/creds)"}}]}`
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+
+			GinkgoT().Setenv("VCAP_SERVICES", vcapServicesValue)
+			GinkgoT().Setenv("CF_INSTANCE_CERT", "")
+			GinkgoT().Setenv("CF_INSTANCE_KEY", "")
+			GinkgoT().Setenv("CF_SYSTEM_CERT_PATH", "")
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		interpolate := func() error {
+			return credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())
+		}
+
+		Context("with UAA client credentials", func() {
+			BeforeEach(func() {
+				GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%[1]s", "credhub-auth-url": "%[1]s/uaa", "credhub-client": "client", "credhub-secret": "secret"}`, server.URL()))
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/uaa/oauth/token"),
+						ghttp.VerifyForm(map[string][]string{
+							"grant_type":    {"client_credentials"},
+							"client_id":     {"client"},
+							"client_secret": {"secret"},
+						}),
+						ghttp.RespondWith(http.StatusOK, `{"access_token": "uaa-token", "token_type": "bearer"}`),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/api/v1/interpolate"),
+						ghttp.VerifyHeaderKV("Authorization", "Bearer uaa-token"),
+						ghttp.RespondWith(http.StatusOK, "INTERPOLATED_JSON"),
+					),
+				)
+			})
+
+			It("interpolates with a UAA token", func() {
+				Expect(interpolate()).To(Succeed())
+				Expect(os.Getenv("VCAP_SERVICES")).To(Equal("INTERPOLATED_JSON"))
+			})
+		})
+
+		Context("with a static token", func() {
+			BeforeEach(func() {
+				GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s", "credhub-auth": "token", "credhub-token": "static-token"}`, server.URL()))
+
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/api/v1/interpolate"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer static-token"),
+					ghttp.RespondWith(http.StatusOK, "INTERPOLATED_JSON"),
+				))
+			})
+
+			It("interpolates with the token", func() {
+				Expect(interpolate()).To(Succeed())
+				Expect(os.Getenv("VCAP_SERVICES")).To(Equal("INTERPOLATED_JSON"))
+			})
+		})
+
+		It("fails when the token is missing", func() {
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s", "credhub-auth": "token"}`, server.URL()))
+			Expect(interpolate()).To(MatchError("unable to set up credhub client: missing credhub-token"))
+		})
+
+		It("ignores credentials in the app env", func() {
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s", "credhub-auth": "token"}`, server.URL()))
+			GinkgoT().Setenv("CREDHUB_TOKEN", "app-token")
+			GinkgoT().Setenv("CREDHUB_CLIENT", "app-client")
+			GinkgoT().Setenv("CREDHUB_SECRET", "app-secret")
+			Expect(interpolate()).To(MatchError("unable to set up credhub client: missing credhub-token"))
+		})
+
+		It("uses the instance identity when the app env has credentials", func() {
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s"}`, server.URL()))
+			GinkgoT().Setenv("CREDHUB_TOKEN", "app-token")
+			Expect(interpolate()).To(MatchError(ContainSubstring("missing CF_INSTANCE_CERT")))
+		})
+
+		It("fails for unknown auth modes", func() {
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s", "credhub-auth": "kerberos"}`, server.URL()))
+			Expect(interpolate()).To(MatchError(`unable to set up credhub client: unknown credhub auth "kerberos"`))
+		})
+	})
 })
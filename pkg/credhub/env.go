@@ -0,0 +1,234 @@
+package credhub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+)
+
+const refKey = "credhub-ref"
+
+// placeholder marks the position of the n-th credhub reference while the references are interpolated
+type placeholder int
+
+// InterpolateEnv replaces credhub references in the given env vars. A reference is a JSON object of the form
+// {"credhub-ref": "/path"}, either as the whole value or nested in a JSON value.
+func InterpolateEnv(names []string, retry Retry, logger *log.Logger) error {
+	values := map[string]string{}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			values[name] = value
+		}
+	}
+
+	interpolated, err := interpolateValues(values, retry, logger)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range interpolated {
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("unable to update %s with interpolated credhub references: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// InterpolateEnvFiles replaces credhub references in the files of a platform env dir
+func InterpolateEnvFiles(envDir string, retry Retry, logger *log.Logger) error {
+	entries, err := os.ReadDir(envDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	values := map[string]string{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(envDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		values[entry.Name()] = string(content)
+	}
+
+	interpolated, err := interpolateValues(values, retry, logger)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range interpolated {
+		if err := os.WriteFile(filepath.Join(envDir, name), []byte(value), 0o644); err != nil {
+			return fmt.Errorf("unable to update env file %s with interpolated credhub references: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// interpolateValues resolves the credhub references of all values with a single request and returns the
+// values that contained references
+func interpolateValues(values map[string]string, retry Retry, logger *log.Logger) (map[string]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	docs := map[string]any{}
+	refs := []any{}
+	for _, name := range names {
+		if !strings.Contains(values[name], refKey) {
+			continue
+		}
+
+		var doc any
+		if err := json.Unmarshal([]byte(values[name]), &doc); err != nil {
+			continue
+		}
+
+		count := len(refs)
+		doc = walkRefs(doc, func(ref map[string]any) any {
+			refs = append(refs, ref)
+			return placeholder(len(refs) - 1)
+		})
+		if len(refs) > count {
+			docs[name] = doc
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	platformOptions, err := getPlatformOptions()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get platform options: %s", err)
+	}
+	if platformOptions.resolverName() == "" || os.Getenv("CREDHUB_SKIP_INTERPOLATION") != "" {
+		return nil, nil
+	}
+
+	resolver, err := newResolver(platformOptions, retry.Transport, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// the interpolate endpoint expects the VCAP_SERVICES format
+	request := []map[string]any{}
+	for _, ref := range refs {
+		request = append(request, map[string]any{"credentials": ref})
+	}
+	body, err := json.Marshal(map[string]any{"env": request})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := interpolate(resolver, string(body), retry, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := struct {
+		Env []struct {
+			Credentials any `json:"credentials"`
+		} `json:"env"`
+	}{}
+	if err := json.Unmarshal([]byte(response), &resolved); err != nil {
+		return nil, fmt.Errorf("unable to parse interpolated credhub references: %v", err)
+	}
+	if len(resolved.Env) != len(refs) {
+		return nil, fmt.Errorf("unable to interpolate credhub references: expected %d credentials, got %d", len(refs), len(resolved.Env))
+	}
+	for _, env := range resolved.Env {
+		log.AddSecretValues(env.Credentials)
+	}
+
+	interpolated := map[string]string{}
+	for name, doc := range docs {
+		doc = walkPlaceholders(doc, func(p placeholder) any {
+			return resolved.Env[p].Credentials
+		})
+
+		value, err := encodeValue(doc)
+		if err != nil {
+			return nil, err
+		}
+		interpolated[name] = value
+	}
+
+	return interpolated, nil
+}
+
+func isRef(value map[string]any) bool {
+	_, ok := value[refKey].(string)
+	return ok && len(value) == 1
+}
+
+func walkRefs(value any, fn func(ref map[string]any) any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		if isRef(v) {
+			return fn(v)
+		}
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			v[key] = walkRefs(v[key], fn)
+		}
+	case []any:
+		for i := range v {
+			v[i] = walkRefs(v[i], fn)
+		}
+	}
+
+	return value
+}
+
+func walkPlaceholders(value any, fn func(p placeholder) any) any {
+	switch v := value.(type) {
+	case placeholder:
+		return fn(v)
+	case map[string]any:
+		for key := range v {
+			v[key] = walkPlaceholders(v[key], fn)
+		}
+	case []any:
+		for i := range v {
+			v[i] = walkPlaceholders(v[i], fn)
+		}
+	}
+
+	return value
+}
+
+// encodeValue returns strings as is and everything else as JSON
+func encodeValue(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,118 @@
+package credhub_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/credhub"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("credhub env", func() {
+	var server *ghttp.Server
+
+	BeforeEach(func() {
+		server = ghttp.NewUnstartedServer()
+
+		cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "server.crt"), filepath.Join(certDir, "server.key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		caCerts := x509.NewCertPool()
+		caCertBytes, err := os.ReadFile(filepath.Join(certDir, "cacerts", "ca.crt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caCerts.AppendCertsFromPEM(caCertBytes)).To(BeTrue())
+
+		server.HTTPTestServer.TLS = &tls.Config{
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    caCerts,
+		}
+		server.HTTPTestServer.StartTLS()
+
+		GinkgoT().Setenv("CF_INSTANCE_CERT", filepath.Join(certDir, "client.crt"))
+		GinkgoT().Setenv("CF_INSTANCE_KEY", filepath.Join(certDir, "client.key"))
+		GinkgoT().Setenv("CF_SYSTEM_CERT_PATH", filepath.Join(certDir, "cacerts"))
+		GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"credhub-uri": "%s"}`, server.URL()))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("InterpolateEnv", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("CNB_REGISTRY_CREDS", `{"registry.example.com": {"credhub-ref": "/c/registry"}}`)
+			GinkgoT().Setenv("BP_TOKEN", `{"credhub-ref": "/c/token"}`)
+			GinkgoT().Setenv("BP_PLAIN", "plain value")
+		})
+
+		It("interpolates references of the given env vars in a single request", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/api/v1/interpolate"),
+				ghttp.VerifyJSON(`{"env": [
+					{"credentials": {"credhub-ref": "/c/token"}},
+					{"credentials": {"credhub-ref": "/c/registry"}}
+				]}`),
+				ghttp.RespondWith(http.StatusOK, `{"env": [
+					{"credentials": "s3cr3t"},
+					{"credentials": {"username": "user", "password": "p<w>"}}
+				]}`),
+			))
+
+			Expect(credhub.InterpolateEnv([]string{"CNB_REGISTRY_CREDS", "BP_TOKEN", "BP_PLAIN", "BP_UNSET"}, credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("BP_TOKEN")).To(Equal("s3cr3t"))
+			Expect(os.Getenv("CNB_REGISTRY_CREDS")).To(MatchJSON(`{"registry.example.com": {"username": "user", "password": "p<w>"}}`))
+			Expect(os.Getenv("CNB_REGISTRY_CREDS")).To(ContainSubstring("p<w>"))
+			Expect(os.Getenv("BP_PLAIN")).To(Equal("plain value"))
+		})
+
+		It("does not contact credhub without references", func() {
+			Expect(credhub.InterpolateEnv([]string{"BP_PLAIN"}, credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("does not interpolate when interpolation is skipped", func() {
+			GinkgoT().Setenv("CREDHUB_SKIP_INTERPOLATION", "true")
+
+			Expect(credhub.InterpolateEnv([]string{"BP_TOKEN"}, credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("BP_TOKEN")).To(Equal(`{"credhub-ref": "/c/token"}`))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("returns an error and keeps the env vars when credhub fails", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, "{}"))
+
+			err := credhub.InterpolateEnv([]string{"BP_TOKEN"}, credhub.Retry{Attempts: 1}, log.NewLogger())
+			Expect(err).To(MatchError(ContainSubstring("unable to interpolate credhub references")))
+			Expect(os.Getenv("BP_TOKEN")).To(Equal(`{"credhub-ref": "/c/token"}`))
+		})
+	})
+
+	Describe("InterpolateEnvFiles", func() {
+		It("interpolates references in env files", func() {
+			envDir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(envDir, "BP_TOKEN"), []byte(`{"credhub-ref": "/c/token"}`), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(envDir, "BP_PLAIN"), []byte("plain"), 0o644)).To(Succeed())
+
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyJSON(`{"env": [{"credentials": {"credhub-ref": "/c/token"}}]}`),
+				ghttp.RespondWith(http.StatusOK, `{"env": [{"credentials": "s3cr3t"}]}`),
+			))
+
+			Expect(credhub.InterpolateEnvFiles(envDir, credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.ReadFile(filepath.Join(envDir, "BP_TOKEN"))).To(BeEquivalentTo("s3cr3t"))
+			Expect(os.ReadFile(filepath.Join(envDir, "BP_PLAIN"))).To(BeEquivalentTo("plain"))
+		})
+
+		It("ignores a missing env dir", func() {
+			Expect(credhub.InterpolateEnvFiles(filepath.Join(GinkgoT().TempDir(), "missing"), credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+		})
+	})
+})
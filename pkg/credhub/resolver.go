@@ -0,0 +1,255 @@
+package credhub
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/transport"
+	api "code.cloudfoundry.org/credhub-cli/credhub"
+)
+
+// maxErrorBody is the number of bytes of an error response kept in the error
+const maxErrorBody = 256
+
+const (
+	CredHubResolver = "credhub"
+	FileResolver    = "file"
+	HTTPResolver    = "http"
+)
+
+// Resolver replaces the credhub references in a VCAP_SERVICES formatted document
+type Resolver interface {
+	Interpolate(vcapServices string) (string, error)
+}
+
+func (o platformOptions) resolverName() string {
+	if o.SecretResolver == "" && o.CredhubURI != "" {
+		return CredHubResolver
+	}
+
+	return o.SecretResolver
+}
+
+func newResolver(options platformOptions, transportOptions transport.Options, logger *log.Logger) (Resolver, error) {
+	switch options.resolverName() {
+	case CredHubResolver:
+		ch, err := credhubClient(options)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set up credhub client: %v", err)
+		}
+		return &credhubResolver{client: ch}, nil
+	case FileResolver:
+		if options.SecretDir == "" {
+			return nil, fmt.Errorf("missing secret-dir for the %q secret resolver", FileResolver)
+		}
+		return &RefResolver{Resolve: (&FileSecrets{Dir: options.SecretDir}).Resolve}, nil
+	case HTTPResolver:
+		if options.SecretResolverURI == "" {
+			return nil, fmt.Errorf("missing secret-resolver-uri for the %q secret resolver", HTTPResolver)
+		}
+		client, err := httpResolverClient(transportOptions, logger)
+		if err != nil {
+			return nil, fmt.Errorf("unable to set up secret resolver client: %v", err)
+		}
+		return &RefResolver{Resolve: (&HTTPSecrets{URL: options.SecretResolverURI, Client: client}).Resolve}, nil
+	default:
+		return nil, fmt.Errorf("unknown secret resolver %q", options.SecretResolver)
+	}
+}
+
+type credhubResolver struct {
+	client *api.CredHub
+}
+
+func (r *credhubResolver) Interpolate(vcapServices string) (string, error) {
+	requestBody := map[string]any{}
+	if err := json.Unmarshal([]byte(vcapServices), &requestBody); err != nil {
+		return "", err
+	}
+
+	resp, err := r.client.Request(http.MethodPost, "/api/v1/interpolate", nil, requestBody, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: resp.StatusCode, Body: errorBody(body)}
+	}
+
+	return string(body), nil
+}
+
+// RefResolver interpolates a VCAP_SERVICES formatted document by resolving every reference on its own
+type RefResolver struct {
+	Resolve func(ref string) (any, error)
+}
+
+func (r *RefResolver) Interpolate(vcapServices string) (string, error) {
+	services := map[string][]map[string]any{}
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return "", err
+	}
+
+	for _, instances := range services {
+		for _, instance := range instances {
+			credentials, ok := instance["credentials"].(map[string]any)
+			if !ok || !isRef(credentials) {
+				continue
+			}
+
+			value, err := r.Resolve(credentials[refKey].(string))
+			if err != nil {
+				return "", err
+			}
+			instance["credentials"] = value
+		}
+	}
+
+	return encodeValue(services)
+}
+
+// FileSecrets resolves references to files in a mounted secret directory, a reference to a directory resolves to an
+// object of its files
+type FileSecrets struct {
+	Dir string
+}
+
+func (f *FileSecrets) Resolve(ref string) (any, error) {
+	name := strings.Trim(ref, "()/")
+	if name == "" || !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid secret reference %q", ref)
+	}
+
+	path := filepath.Join(f.Dir, filepath.FromSlash(name))
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read secret %q: %w", ref, errors.ErrSecretNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret %q: %v", ref, err)
+	}
+
+	if !fi.IsDir() {
+		return readSecretFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret %q: %v", ref, err)
+	}
+
+	secret := map[string]any{}
+	for _, entry := range entries {
+		// skip hidden files like the "..data" symlinks of Kubernetes secret volumes
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+
+		value, err := readSecretFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		secret[entry.Name()] = value
+	}
+
+	return secret, nil
+}
+
+// readSecretFile returns the content of a file holding a JSON object or array as JSON, or its content as string
+func readSecretFile(path string) (any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(content, &value); err == nil {
+		switch value.(type) {
+		case map[string]any, []any:
+			return value, nil
+		}
+	}
+
+	return strings.TrimSuffix(string(content), "\n"), nil
+}
+
+// HTTPSecrets resolves references with a GET request to URL with the reference in the "name" query parameter,
+// the JSON response is the credential
+type HTTPSecrets struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h *HTTPSecrets) Resolve(ref string) (any, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set("name", ref)
+	u.RawQuery = query.Encode()
+
+	resp, err := h.Client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to resolve secret %q: %w", ref, &StatusError{StatusCode: resp.StatusCode, Body: errorBody(body)})
+	}
+
+	var value any
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&value); err != nil {
+		return nil, fmt.Errorf("unable to parse secret %q: %v", ref, err)
+	}
+
+	return value, nil
+}
+
+// errorBody returns the start of an error response with secrets masked, as it may echo the credentials
+func errorBody(body []byte) string {
+	msg := log.Redact(strings.TrimSpace(string(body)))
+	if len(msg) > maxErrorBody {
+		msg = strings.ToValidUTF8(msg[:maxErrorBody], "") + "..."
+	}
+
+	return msg
+}
+
+// httpResolverClient authenticates with the instance identity and trusts the CA certs of the transport options, or the
+// system certs if there are none. Failed requests are retried by the interpolation, not by the transport.
+func httpResolverClient(options transport.Options, logger *log.Logger) (*http.Client, error) {
+	options.CACerts = cmp.Or(options.CACerts, os.Getenv("CF_SYSTEM_CERT_PATH"))
+	options.ClientCert = os.Getenv("CF_INSTANCE_CERT")
+	options.ClientKey = os.Getenv("CF_INSTANCE_KEY")
+	options.Attempts = 1
+
+	rt, err := transport.New(options, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: rt}, nil
+}
@@ -0,0 +1,191 @@
+package credhub_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/credhub"
+	cnberrors "code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/transport"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("secret resolvers", func() {
+	const vcapServices = `{"my-server":[{"name":"server","credentials":{"credhub-ref":"(//my-server/creds)"}}],"other":[{"name":"plain","credentials":{"user":"u"}}]}`
+
+	BeforeEach(func() {
+		GinkgoT().Setenv("VCAP_SERVICES", vcapServices)
+		GinkgoT().Setenv("CF_INSTANCE_CERT", "")
+		GinkgoT().Setenv("CF_INSTANCE_KEY", "")
+		GinkgoT().Setenv("CF_SYSTEM_CERT_PATH", "")
+	})
+
+	Describe("file resolver", func() {
+		var secretDir string
+
+		BeforeEach(func() {
+			secretDir = GinkgoT().TempDir()
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"secret-resolver": "file", "secret-dir": %q}`, secretDir))
+		})
+
+		It("resolves references to a secret directory", func() {
+			Expect(os.MkdirAll(filepath.Join(secretDir, "my-server", "creds"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(secretDir, "my-server", "creds", "password"), []byte("s3cr3t\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(secretDir, "my-server", "creds", "port"), []byte("5432"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(secretDir, "my-server", "creds", ".hidden"), []byte("x"), 0o644)).To(Succeed())
+
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("VCAP_SERVICES")).To(MatchJSON(`{
+				"my-server":[{"name":"server","credentials":{"password":"s3cr3t","port":"5432"}}],
+				"other":[{"name":"plain","credentials":{"user":"u"}}]
+			}`))
+		})
+
+		It("resolves references to a JSON file", func() {
+			Expect(os.MkdirAll(filepath.Join(secretDir, "my-server"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(secretDir, "my-server", "creds"), []byte(`{"uri":"postgres://db"}`), 0o644)).To(Succeed())
+
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("VCAP_SERVICES")).To(ContainSubstring(`"credentials":{"uri":"postgres://db"}`))
+		})
+
+		It("fails for missing secrets", func() {
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())
+			Expect(err).To(MatchError(ContainSubstring(`unable to read secret "(//my-server/creds)"`)))
+			Expect(err).To(MatchError(cnberrors.ErrSecretNotFound))
+			Expect(os.Getenv("VCAP_SERVICES")).To(Equal(vcapServices))
+		})
+
+		It("rejects references outside of the secret dir", func() {
+			resolver := credhub.FileSecrets{Dir: secretDir}
+			_, err := resolver.Resolve("/../etc/passwd")
+			Expect(err).To(MatchError(`invalid secret reference "/../etc/passwd"`))
+		})
+
+		It("fails without a secret dir", func() {
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", `{"secret-resolver": "file"}`)
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(MatchError(`missing secret-dir for the "file" secret resolver`))
+		})
+	})
+
+	Describe("http resolver", func() {
+		var server *ghttp.Server
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"secret-resolver": "http", "secret-resolver-uri": "%s/secrets"}`, server.URL()))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("resolves references with the secret service", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/secrets", "name=%28%2F%2Fmy-server%2Fcreds%29"),
+				ghttp.RespondWith(http.StatusOK, `{"password":"s3cr3t"}`),
+			))
+
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("VCAP_SERVICES")).To(ContainSubstring(`"credentials":{"password":"s3cr3t"}`))
+		})
+
+		It("trusts the CA certs of the transport options", func() {
+			tlsServer := ghttp.NewTLSServer()
+			defer tlsServer.Close()
+			tlsServer.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{"password":"s3cr3t"}`))
+			GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", fmt.Sprintf(`{"secret-resolver": "http", "secret-resolver-uri": "%s/secrets"}`, tlsServer.URL()))
+
+			caFile := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+			Expect(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.HTTPTestServer.Certificate().Raw}), 0o644)).To(Succeed())
+
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(MatchError(ContainSubstring("certificate")))
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1, Transport: transport.Options{CACerts: caFile}}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("VCAP_SERVICES")).To(ContainSubstring(`"credentials":{"password":"s3cr3t"}`))
+		})
+
+		It("retries failed requests", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+				ghttp.RespondWith(http.StatusOK, `{"password":"s3cr3t"}`),
+			)
+
+			Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 2}, log.NewLogger())).To(Succeed())
+			Expect(os.Getenv("VCAP_SERVICES")).To(ContainSubstring(`"credentials":{"password":"s3cr3t"}`))
+		})
+
+		It("fails without retrying when the secret cannot be resolved", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 3}, log.NewLogger())
+			Expect(err).To(MatchError(ContainSubstring(`unable to resolve secret "(//my-server/creds)": Not Found`)))
+			Expect(err).To(MatchError(cnberrors.ErrSecretNotFound))
+			Expect(credhub.ExitError(err, cnberrors.ErrGenericBuild, cnberrors.PhasePreparing)).To(MatchError(cnberrors.ErrSecretNotFound))
+			Expect(cnberrors.ExitCodeFromError(credhub.ExitError(err, cnberrors.ErrGenericBuild, cnberrors.PhasePreparing))).To(Equal(238))
+
+			var stagingErr *cnberrors.StagingError
+			Expect(errors.As(credhub.ExitError(err, cnberrors.ErrGenericBuild, cnberrors.PhasePreparing), &stagingErr)).To(BeTrue())
+			Expect(stagingErr.Phase).To(Equal(cnberrors.PhasePreparing))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("masks and truncates the body of error responses", func() {
+			log.AddSecrets("leaked-s3cr3t")
+			server.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, "leaked-s3cr3t "+strings.Repeat("x", 1024)))
+
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())
+			Expect(err).To(MatchError(ContainSubstring(`unable to resolve secret "(//my-server/creds)": Bad Request: [REDACTED] xxx`)))
+			Expect(err).NotTo(MatchError(ContainSubstring("leaked-s3cr3t")))
+			Expect(err).To(MatchError(HaveSuffix("x...")))
+			Expect(len(err.Error())).To(BeNumerically("<", 512))
+		})
+
+		It("fails as unavailable when all attempts fail", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusBadGateway, ""),
+				ghttp.RespondWith(http.StatusBadGateway, ""),
+			)
+
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 2, Delay: time.Millisecond}, log.NewLogger())
+			Expect(err).To(MatchError(cnberrors.ErrSecretUnavailable))
+			Expect(credhub.ExitError(err, cnberrors.ErrGenericBuild, cnberrors.PhasePreparing)).To(MatchError(cnberrors.ErrSecretUnavailable))
+			Expect(cnberrors.ExitCodeFromError(credhub.ExitError(err, cnberrors.ErrGenericBuild, cnberrors.PhasePreparing))).To(Equal(239))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("stops retrying when the deadline would be exceeded", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 5, Delay: time.Hour, Deadline: time.Minute}, log.NewLogger())
+			Expect(err).To(MatchError(cnberrors.ErrSecretUnavailable))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		It("abandons an attempt that runs past the deadline", func() {
+			release := make(chan struct{})
+			defer close(release)
+			server.AppendHandlers(func(http.ResponseWriter, *http.Request) { <-release })
+
+			start := time.Now()
+			err := credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 5, Delay: time.Millisecond, Deadline: 100 * time.Millisecond}, log.NewLogger())
+			Expect(err).To(MatchError(cnberrors.ErrSecretUnavailable))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
+
+	It("fails for unknown resolvers", func() {
+		GinkgoT().Setenv("VCAP_PLATFORM_OPTIONS", `{"secret-resolver": "vault"}`)
+		Expect(credhub.InterpolateServiceRefs(credhub.Retry{Attempts: 1}, log.NewLogger())).To(MatchError(`unknown secret resolver "vault"`))
+	})
+})
@@ -0,0 +1,132 @@
+package credhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	cnberrors "code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/transport"
+)
+
+// Retry configures how often and how long interpolation is attempted. The delay doubles after every attempt, with
+// jitter, no attempt is started once the deadline would be exceeded and a running attempt is abandoned at the deadline.
+type Retry struct {
+	Attempts int
+	Delay    time.Duration
+	Deadline time.Duration
+	// Transport configures the CA certs and proxies of the HTTP secret resolver
+	Transport transport.Options
+}
+
+// StatusError is returned for unsuccessful responses of the secret store
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case cnberrors.ErrSecretNotFound:
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusForbidden || e.StatusCode == http.StatusUnauthorized
+	case cnberrors.ErrSecretUnavailable:
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// retryable reports whether an error is caused by the network or a temporary failure of the secret store
+func retryable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, cnberrors.ErrSecretUnavailable)
+}
+
+func interpolate(resolver Resolver, body string, retry Retry, logger *log.Logger) (string, error) {
+	start := time.Now()
+	retry.Attempts = max(retry.Attempts, 1)
+	var deadline time.Time
+	if retry.Deadline > 0 {
+		deadline = start.Add(retry.Deadline)
+	}
+
+	var err error
+	for attempt := 1; attempt <= retry.Attempts; attempt++ {
+		var interpolated string
+		if interpolated, err = interpolateUntil(resolver, body, deadline); err == nil {
+			return interpolated, nil
+		}
+
+		if !retryable(err) {
+			break
+		}
+		logger.Warnf("Failed on attempt %d out of %d: unable to interpolate credhub references: %v", attempt, retry.Attempts, err)
+
+		if attempt == retry.Attempts {
+			break
+		}
+
+		delay := transport.Backoff(retry.Delay, attempt)
+		if retry.Deadline > 0 && time.Since(start)+delay > retry.Deadline {
+			logger.Warnf("Giving up interpolating credhub references, deadline of %s exceeded", retry.Deadline)
+			break
+		}
+		time.Sleep(delay)
+	}
+
+	if retryable(err) {
+		return "", fmt.Errorf("unable to interpolate credhub references: %w: %w", cnberrors.ErrSecretUnavailable, err)
+	}
+	return "", fmt.Errorf("unable to interpolate credhub references: %w", err)
+}
+
+// interpolateUntil returns context.DeadlineExceeded if the resolver does not return before deadline. The credhub
+// client takes no context, so the request is left running instead of being canceled.
+func interpolateUntil(resolver Resolver, body string, deadline time.Time) (string, error) {
+	if deadline.IsZero() {
+		return resolver.Interpolate(body)
+	}
+
+	type result struct {
+		interpolated string
+		err          error
+	}
+	done := make(chan result, 1)
+	go func() {
+		interpolated, err := resolver.Interpolate(body)
+		done <- result{interpolated, err}
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.interpolated, r.err
+	case <-timer.C:
+		return "", context.DeadlineExceeded
+	}
+}
+
+// ExitError wraps an interpolation error of phase with the exit code error, which is fallback if it is neither a
+// missing secret nor an unavailable secret store
+func ExitError(err error, fallback error, phase string) error {
+	kind := fallback
+	switch {
+	case errors.Is(err, cnberrors.ErrSecretNotFound):
+		kind = cnberrors.ErrSecretNotFound
+	case errors.Is(err, cnberrors.ErrSecretUnavailable):
+		kind = cnberrors.ErrSecretUnavailable
+	}
+	return cnberrors.Wrap(kind, phase, err)
+}
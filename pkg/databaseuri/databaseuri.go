@@ -1,48 +1,320 @@
 package databaseuri
 
 import (
-	"encoding/json"
+	"fmt"
+	"net"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
 )
 
-func ParseDatabaseURI(services string) (string, error) {
-	if services == "" {
-		return "", nil
+const (
+	DatabaseURLEnv     = "DATABASE_URL"
+	JDBCDatabaseURLEnv = "JDBC_DATABASE_URL"
+	// ServiceNameEnv pins the service instance used for DATABASE_URL
+	ServiceNameEnv = "DATABASE_SERVICE_NAME"
+	// SchemesEnv adds schemes to the scheme table, as a comma separated list of "scheme" or "scheme=target"
+	SchemesEnv = "DATABASE_URL_SCHEMES"
+	// JDBCEnv enables JDBC_DATABASE_URL
+	JDBCEnv = "DATABASE_URL_JDBC"
+)
+
+// databaseTags are preferred when several services provide a database URI
+var databaseTags = []string{"database", "relational"}
+
+// Scheme describes how a service scheme is written to DATABASE_URL
+type Scheme struct {
+	// Target is the scheme used in DATABASE_URL
+	Target string
+	// JDBC is the JDBC subprotocol, empty if there is no JDBC driver
+	JDBC string
+}
+
+type Schemes map[string]Scheme
+
+// DefaultSchemes are the relational databases used for DATABASE_URL
+var DefaultSchemes = Schemes{
+	"mysql":      {Target: "mysql2", JDBC: "mysql"},
+	"mysql2":     {Target: "mysql2", JDBC: "mysql"},
+	"postgres":   {Target: "postgres", JDBC: "postgresql"},
+	"postgresql": {Target: "postgres", JDBC: "postgresql"},
+	"sqlserver":  {Target: "sqlserver", JDBC: "sqlserver"},
+	"mssql":      {Target: "sqlserver", JDBC: "sqlserver"},
+	"oracle":     {Target: "oracle", JDBC: "oracle:thin"},
+}
+
+// optionalSchemes can be enabled with DATABASE_URL_SCHEMES
+var optionalSchemes = Schemes{
+	"mongodb":     {Target: "mongodb"},
+	"mongodb+srv": {Target: "mongodb+srv"},
+	"redis":       {Target: "redis"},
+	"rediss":      {Target: "rediss"},
+}
+
+type Binding struct {
+	Name    string
+	Label   string
+	URI     string
+	JDBCURI string
+}
+
+// ParseSchemes returns the default schemes extended by a comma separated list of "scheme" or "scheme=target" entries
+func ParseSchemes(value string) (Schemes, error) {
+	schemes := Schemes{}
+	for name, scheme := range DefaultSchemes {
+		schemes[name] = scheme
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, target, hasTarget := strings.Cut(entry, "=")
+		if name == "" || (hasTarget && target == "") {
+			return nil, fmt.Errorf("invalid scheme %q in %s", entry, SchemesEnv)
+		}
+
+		scheme, ok := schemes[name]
+		if !ok {
+			scheme, ok = optionalSchemes[name]
+		}
+		if !ok {
+			scheme = Scheme{Target: name}
+		}
+		if hasTarget {
+			scheme.Target = target
+		}
+
+		schemes[name] = scheme
 	}
 
-	data := map[string][]struct {
-		Credentials struct {
-			Uri string `json:"uri"`
-		} `json:"credentials"`
-	}{}
-	if err := json.Unmarshal([]byte(services), &data); err != nil {
+	return schemes, nil
+}
+
+func ParseDatabaseURI(services string) (string, error) {
+	data, err := vcap.ParseServices(services, vcap.Strict)
+	if err != nil {
 		return "", err
 	}
 
-	var creds []string
-	for _, v1 := range data {
-		for _, v2 := range v1 {
-			if v2.Credentials.Uri != "" {
-				creds = append(creds, v2.Credentials.Uri)
-			}
+	binding, _, err := Select(data, "", DefaultSchemes)
+	return binding.URI, err
+}
+
+// Select returns the binding to use for DATABASE_URL. Services tagged as database come first, then services are
+// ordered by name; serviceName pins the service instance to use.
+func Select(services vcap.Services, serviceName string, schemes Schemes) (Binding, bool, error) {
+	if serviceName != "" {
+		svc, ok := services.ByName(serviceName)
+		if !ok {
+			return Binding{}, false, fmt.Errorf("service %q set in %s not found", serviceName, ServiceNameEnv)
+		}
+
+		binding, ok := databaseBinding(svc, schemes)
+		if !ok {
+			return Binding{}, false, fmt.Errorf("service %q set in %s has no supported database URI", serviceName, ServiceNameEnv)
+		}
+
+		return binding, true, nil
+	}
+
+	candidates := services.All()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ti, tj := hasDatabaseTag(candidates[i]), hasDatabaseTag(candidates[j])
+		if ti != tj {
+			return ti
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	for _, svc := range candidates {
+		if binding, ok := databaseBinding(svc, schemes); ok {
+			return binding, true, nil
+		}
+	}
+
+	return Binding{}, false, nil
+}
+
+// Export sets DATABASE_URL, and JDBC_DATABASE_URL if enabled, from VCAP_SERVICES
+func Export(logger *log.Logger) error {
+	services, err := vcap.ServicesFromEnv(vcap.Strict)
+	if err != nil {
+		return err
+	}
+
+	schemes, err := ParseSchemes(os.Getenv(SchemesEnv))
+	if err != nil {
+		return err
+	}
+
+	binding, ok, err := Select(services, os.Getenv(ServiceNameEnv), schemes)
+	if err != nil || !ok {
+		return err
+	}
+
+	addSecrets(binding)
+	logger.Infof("Using service %q (%s) for %s", binding.Name, binding.Label, DatabaseURLEnv)
+	if err := os.Setenv(DatabaseURLEnv, binding.URI); err != nil {
+		return err
+	}
+
+	if jdbc, _ := strconv.ParseBool(os.Getenv(JDBCEnv)); !jdbc || binding.JDBCURI == "" {
+		return nil
+	}
+
+	return os.Setenv(JDBCDatabaseURLEnv, binding.JDBCURI)
+}
+
+// addSecrets masks the database URIs and their password in the log output
+func addSecrets(binding Binding) {
+	log.AddSecrets(binding.URI, binding.JDBCURI)
+	if uri, err := url.Parse(binding.URI); err == nil {
+		if password, ok := uri.User.Password(); ok {
+			log.AddSecrets(password)
 		}
 	}
+}
+
+func hasDatabaseTag(svc vcap.Service) bool {
+	for _, tag := range databaseTags {
+		if svc.HasTag(tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func databaseBinding(svc vcap.Service, schemes Schemes) (Binding, bool) {
+	uri, ok := credentialsURI(svc, schemes)
+	if !ok {
+		return Binding{}, false
+	}
 
-	schemes := map[string]string{
-		"mysql":      "mysql2",
-		"mysql2":     "",
-		"postgres":   "",
-		"postgresql": "postgres",
+	scheme := schemes[uri.Scheme]
+	jdbcURI := jdbcURL(scheme.JDBC, uri)
+	uri.Scheme = scheme.Target
+
+	return Binding{Name: svc.Name, Label: svc.Label, URI: uri.String(), JDBCURI: jdbcURI}, true
+}
+
+// credentialsURI returns credentials.uri, or a URI built from the host, port, username, password and database
+// name credentials
+func credentialsURI(svc vcap.Service, schemes Schemes) (*url.URL, bool) {
+	if serviceURI, ok := svc.Credential("uri"); ok && serviceURI != "" {
+		uri, err := url.Parse(serviceURI)
+		if err != nil {
+			return nil, false
+		}
+
+		_, ok := schemes[uri.Scheme]
+		return uri, ok
+	}
+
+	host := credential(svc, "hostname", "host")
+	scheme := serviceScheme(svc, schemes)
+	if host == "" || scheme == "" {
+		return nil, false
+	}
+
+	uri := &url.URL{Scheme: scheme, Host: host}
+	if port := credential(svc, "port"); port != "" {
+		uri.Host = net.JoinHostPort(host, port)
+	}
+	if username := credential(svc, "username", "user"); username != "" {
+		if password, ok := svc.Credential("password"); ok {
+			uri.User = url.UserPassword(username, password)
+		} else {
+			uri.User = url.User(username)
+		}
+	}
+	if name := credential(svc, "name", "database", "db_name"); name != "" {
+		uri.Path = "/" + name
 	}
-	for _, service_uri := range creds {
-		if uri, err := url.Parse(service_uri); err == nil {
-			if val, ok := schemes[uri.Scheme]; ok {
-				if val != "" {
-					uri.Scheme = val
+
+	return uri, true
+}
+
+// serviceScheme derives the scheme from the scheme or engine credential, the tags or the label of the service
+func serviceScheme(svc vcap.Service, schemes Schemes) string {
+	candidates := []string{credential(svc, "scheme"), credential(svc, "engine")}
+	candidates = append(append(candidates, svc.Tags...), svc.Label)
+
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(candidate)
+		for _, prefix := range []string{"", "p.", "p-"} {
+			if name, ok := strings.CutPrefix(candidate, prefix); ok {
+				if _, known := schemes[name]; known {
+					return name
 				}
-				return uri.String(), nil
 			}
 		}
 	}
-	return "", nil
+
+	return ""
+}
+
+// credential returns the first of the credentials keys that is set, numbers are formatted as integers
+func credential(svc vcap.Service, keys ...string) string {
+	for _, key := range keys {
+		switch value := svc.Credentials[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+
+	return ""
+}
+
+func jdbcURL(subprotocol string, uri *url.URL) string {
+	if subprotocol == "" {
+		return ""
+	}
+
+	database := strings.TrimPrefix(uri.Path, "/")
+	username := uri.User.Username()
+	password, _ := uri.User.Password()
+
+	switch subprotocol {
+	case "sqlserver":
+		jdbc := fmt.Sprintf("jdbc:sqlserver://%s", uri.Host)
+		for _, param := range [][2]string{{"databaseName", database}, {"user", username}, {"password", password}} {
+			if param[1] != "" {
+				jdbc += fmt.Sprintf(";%s={%s}", param[0], strings.ReplaceAll(param[1], "}", "}}"))
+			}
+		}
+		return jdbc
+	case "oracle:thin":
+		jdbc := "jdbc:oracle:thin:"
+		if username != "" {
+			jdbc += fmt.Sprintf(`%s/"%s"`, username, password)
+		}
+		return fmt.Sprintf("%s@//%s/%s", jdbc, uri.Host, database)
+	}
+
+	query := url.Values{}
+	if username != "" {
+		query.Set("user", username)
+	}
+	if password != "" {
+		query.Set("password", password)
+	}
+	for key, values := range uri.Query() {
+		query[key] = values
+	}
+
+	jdbc := url.URL{Scheme: subprotocol, Host: uri.Host, Path: uri.Path, RawQuery: query.Encode()}
+	return "jdbc:" + jdbc.String()
 }
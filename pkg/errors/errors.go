@@ -1,6 +1,9 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrGenericBuild         = errors.New("generic build failure")
@@ -10,24 +13,92 @@ var (
 	ErrBuilding             = errors.New("building failed")
 	ErrExporting            = errors.New("exporting failed")
 	ErrLaunching            = errors.New("launching failed")
+	ErrSecretNotFound       = errors.New("secret not found or forbidden")
+	ErrSecretUnavailable    = errors.New("secret store unavailable")
+)
+
+// exitCodes is checked in order, errors of the secret store come first as they are usually wrapped by the error
+// of the phase that interpolated the secret
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{ErrSecretNotFound, 238},
+	{ErrSecretUnavailable, 239},
+	{ErrGenericBuild, 231},
+	{ErrDownloadingBuildpack, 232},
+	{ErrDetecting, 233},
+	{ErrBuilding, 234},
+	{ErrExporting, 235},
+	{ErrLaunching, 236},
+	{ErrRestoring, 237},
+}
+
+// phases of staging and launching
+const (
+	PhasePreparing   = "preparing"
+	PhaseDownloading = "downloading"
+	PhaseDetecting   = "detecting"
+	PhaseRestoring   = "restoring"
+	PhaseBuilding    = "building"
+	PhaseExporting   = "exporting"
+	PhaseLaunching   = "launching"
 )
 
-var errorMapping = map[error]int{
-	ErrGenericBuild:         231,
-	ErrDownloadingBuildpack: 232,
-	ErrDetecting:            233,
-	ErrBuilding:             234,
-	ErrExporting:            235,
-	ErrLaunching:            236,
-	ErrRestoring:            237,
+// StagingError is the failure of a phase with its cause, the exit code is the one of Kind
+type StagingError struct {
+	// Kind is one of the errors above
+	Kind  error
+	Phase string
+	// Buildpack is the ID of the buildpack that failed, if any
+	Buildpack string
+	Cause     error
 }
 
+// Wrap returns a staging error of kind for cause
+func Wrap(kind error, phase string, cause error) *StagingError {
+	return &StagingError{Kind: kind, Phase: phase, Cause: cause}
+}
+
+// WithBuildpack sets the buildpack that failed
+func (e *StagingError) WithBuildpack(id string) *StagingError {
+	e.Buildpack = id
+	return e
+}
+
+func (e *StagingError) Error() string {
+	msg := e.Kind.Error()
+	if e.Buildpack != "" {
+		msg = fmt.Sprintf("%s: buildpack %s", msg, e.Buildpack)
+	}
+	if e.Cause != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Cause.Error())
+	}
+
+	return msg
+}
+
+func (e *StagingError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+
+	return []error{e.Kind, e.Cause}
+}
+
+// ExitCodeFromError returns the exit code of the kind of the outermost staging error in the chain of err, or of
+// the first error above the chain contains, or 1
 func ExitCodeFromError(err error) int {
-	rc, ok := errorMapping[err]
+	var stagingErr *StagingError
+	if errors.As(err, &stagingErr) {
+		err = stagingErr.Kind
+	}
 
-	if !ok {
-		rc = 1
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
 	}
 
-	return rc
+	return 1
 }
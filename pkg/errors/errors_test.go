@@ -0,0 +1,58 @@
+package errors_test
+
+import (
+	"errors"
+	"fmt"
+
+	cnberrors "code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExitCodeFromError", func() {
+	It("maps the errors to exit codes", func() {
+		Expect(cnberrors.ExitCodeFromError(cnberrors.ErrGenericBuild)).To(Equal(231))
+		Expect(cnberrors.ExitCodeFromError(cnberrors.ErrBuilding)).To(Equal(234))
+		Expect(cnberrors.ExitCodeFromError(cnberrors.ErrSecretUnavailable)).To(Equal(239))
+	})
+
+	It("returns 1 for other errors", func() {
+		Expect(cnberrors.ExitCodeFromError(errors.New("unknown"))).To(Equal(1))
+		Expect(cnberrors.ExitCodeFromError(nil)).To(Equal(1))
+	})
+
+	It("maps wrapped errors", func() {
+		Expect(cnberrors.ExitCodeFromError(fmt.Errorf("bin/build: %w", cnberrors.ErrBuilding))).To(Equal(234))
+		Expect(cnberrors.ExitCodeFromError(errors.Join(errors.New("first"), cnberrors.ErrExporting))).To(Equal(235))
+	})
+
+	It("uses the outermost error", func() {
+		err := cnberrors.Wrap(cnberrors.ErrGenericBuild, "", cnberrors.Wrap(cnberrors.ErrSecretNotFound, "", errors.New("not found")))
+
+		Expect(cnberrors.ExitCodeFromError(err)).To(Equal(231))
+		Expect(cnberrors.ExitCodeFromError(fmt.Errorf("interpolation: %w", err))).To(Equal(231))
+	})
+})
+
+var _ = Describe("StagingError", func() {
+	cause := errors.New("exit status 1")
+
+	It("carries the phase, buildpack and cause", func() {
+		err := fmt.Errorf("staging: %w", cnberrors.Wrap(cnberrors.ErrBuilding, cnberrors.PhaseBuilding, cause).WithBuildpack("some/buildpack"))
+
+		var stagingErr *cnberrors.StagingError
+		Expect(errors.As(err, &stagingErr)).To(BeTrue())
+		Expect(stagingErr.Phase).To(Equal(cnberrors.PhaseBuilding))
+		Expect(stagingErr.Buildpack).To(Equal("some/buildpack"))
+		Expect(err).To(MatchError(cnberrors.ErrBuilding))
+		Expect(err).To(MatchError(cause))
+		Expect(cnberrors.ExitCodeFromError(err)).To(Equal(234))
+	})
+
+	It("describes the failure", func() {
+		Expect(cnberrors.Wrap(cnberrors.ErrBuilding, cnberrors.PhaseBuilding, cause).WithBuildpack("some/buildpack")).To(MatchError("building failed: buildpack some/buildpack: exit status 1"))
+		Expect(cnberrors.Wrap(cnberrors.ErrDetecting, cnberrors.PhaseDetecting, cause)).To(MatchError("detecting failed: exit status 1"))
+		Expect(cnberrors.Wrap(cnberrors.ErrLaunching, cnberrors.PhaseLaunching, nil)).To(MatchError("launching failed"))
+	})
+})
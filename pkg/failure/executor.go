@@ -0,0 +1,83 @@
+package failure
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+
+	"github.com/buildpacks/lifecycle/buildpack"
+	"github.com/buildpacks/lifecycle/log"
+	"github.com/buildpacks/lifecycle/phase"
+)
+
+// BuildExecutor records the buildpack whose build fails, with the exit code and output of bin/build
+func (r *Recorder) BuildExecutor(inner buildpack.BuildExecutor) buildpack.BuildExecutor {
+	return &buildExecutor{inner: inner, recorder: r}
+}
+
+type buildExecutor struct {
+	inner    buildpack.BuildExecutor
+	recorder *Recorder
+}
+
+func (e *buildExecutor) Build(d buildpack.BpDescriptor, inputs buildpack.BuildInputs, logger log.Logger) (buildpack.BuildOutputs, error) {
+	output := newTail(e.recorder.lines)
+	inputs.Out = io.MultiWriter(inputs.Out, output)
+	inputs.Err = io.MultiWriter(inputs.Err, output)
+
+	outputs, err := e.inner.Build(d, inputs, logger)
+	if err != nil {
+		e.recorder.failed(Buildpack{
+			ID:       d.Buildpack.ID,
+			Version:  d.Buildpack.Version,
+			ExitCode: exitCode(err),
+			Output:   output.Lines(),
+		})
+	}
+
+	return outputs, err
+}
+
+// DetectExecutor records the buildpack whose bin/detect errors, with its exit code and output
+func (r *Recorder) DetectExecutor(inner buildpack.DetectExecutor) buildpack.DetectExecutor {
+	return &detectExecutor{inner: inner, recorder: r}
+}
+
+type detectExecutor struct {
+	inner    buildpack.DetectExecutor
+	recorder *Recorder
+}
+
+func (e *detectExecutor) Detect(d buildpack.Descriptor, inputs buildpack.DetectInputs, logger log.Logger) buildpack.DetectOutputs {
+	outputs := e.inner.Detect(d, inputs, logger)
+
+	bp, ok := d.(*buildpack.BpDescriptor)
+	if !ok || outputs.Code == phase.CodeDetectPass || outputs.Code == phase.CodeDetectFail {
+		return outputs
+	}
+
+	output := newTail(e.recorder.lines)
+	_, _ = output.Write(outputs.Output)
+	e.recorder.failed(Buildpack{
+		ID:       bp.Buildpack.ID,
+		Version:  bp.Buildpack.Version,
+		ExitCode: outputs.Code,
+		Output:   output.Lines(),
+	})
+
+	return outputs
+}
+
+// exitCode returns the exit code of bin/build, the lifecycle does not wrap its error for errors.As
+func exitCode(err error) int {
+	if bpErr, ok := err.(*buildpack.Error); ok {
+		err = bpErr.Cause()
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
@@ -0,0 +1,164 @@
+package failure
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	cnberrors "code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+)
+
+const DefaultOutputLines = 50
+
+// Report is written when staging fails, so the platform can show why without scraping the logs
+type Report struct {
+	Phase    string `json:"phase"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error"`
+	// Causes are the messages logged at error level, oldest first
+	Causes []string `json:"causes,omitempty"`
+	// Chain is the error staging failed with and the errors it wraps, outermost first
+	Chain     []ChainError `json:"chain,omitempty"`
+	Buildpack *Buildpack   `json:"buildpack,omitempty"`
+}
+
+// ChainError is an error of the chain, Kind, Phase and Buildpack are set for staging errors
+type ChainError struct {
+	Error     string `json:"error"`
+	Kind      string `json:"kind,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	Buildpack string `json:"buildpack,omitempty"`
+}
+
+// Buildpack is the buildpack that failed detect or build
+type Buildpack struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"`
+	// ExitCode of bin/detect or bin/build, -1 if the buildpack did not exit with an error
+	ExitCode int `json:"exit_code"`
+	// Output holds the last lines the buildpack printed
+	Output []string `json:"output,omitempty"`
+}
+
+// Recorder collects what is needed for the report while staging runs
+type Recorder struct {
+	mu        sync.Mutex
+	lines     int
+	phase     string
+	causes    []string
+	buildpack *Buildpack
+}
+
+// NewRecorder keeps the last lines of buildpack output
+func NewRecorder(lines int) *Recorder {
+	return &Recorder{lines: lines, phase: cnberrors.PhasePreparing}
+}
+
+// Phase sets the phase failures are reported for, a buildpack failure of the previous phase did not fail staging
+func (r *Recorder) Phase(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.phase = strings.ToLower(name)
+	r.buildpack = nil
+}
+
+// Error records a message logged at error level
+func (r *Recorder) Error(message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.causes = append(r.causes, message)
+}
+
+func (r *Recorder) failed(bp Buildpack) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// detect runs buildpacks in parallel, the first failure is reported
+	if r.buildpack == nil {
+		r.buildpack = &bp
+	}
+}
+
+// Buildpack returns the ID of the buildpack that failed in the current phase, if any
+func (r *Recorder) Buildpack() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.buildpack == nil {
+		return ""
+	}
+	return r.buildpack.ID
+}
+
+// Report returns the report for the error staging failed with, with secrets masked
+func (r *Recorder) Report(err error) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := Report{
+		Phase:    r.phase,
+		ExitCode: cnberrors.ExitCodeFromError(err),
+		Error:    log.Redact(err.Error()),
+		Chain:    chain(err),
+	}
+	var stagingErr *cnberrors.StagingError
+	if errors.As(err, &stagingErr) && stagingErr.Phase != "" {
+		report.Phase = stagingErr.Phase
+	}
+	for _, cause := range r.causes {
+		report.Causes = append(report.Causes, log.Redact(cause))
+	}
+	if r.buildpack != nil {
+		bp := *r.buildpack
+		bp.Output = nil
+		for _, line := range r.buildpack.Output {
+			bp.Output = append(bp.Output, log.Redact(line))
+		}
+		report.Buildpack = &bp
+	}
+
+	return report
+}
+
+// chain walks err and the errors it wraps depth first, the kind of a staging error is recorded on its entry
+func chain(err error) []ChainError {
+	if err == nil {
+		return nil
+	}
+
+	entry := ChainError{Error: log.Redact(err.Error())}
+	var wrapped []error
+	switch e := err.(type) {
+	case *cnberrors.StagingError:
+		entry.Kind = log.Redact(e.Kind.Error())
+		entry.Phase = e.Phase
+		entry.Buildpack = e.Buildpack
+		wrapped = []error{e.Cause}
+	case interface{ Unwrap() []error }:
+		wrapped = e.Unwrap()
+	default:
+		wrapped = []error{errors.Unwrap(err)}
+	}
+
+	entries := []ChainError{entry}
+	for _, w := range wrapped {
+		entries = append(entries, chain(w)...)
+	}
+
+	return entries
+}
+
+// Write writes the report for err to path
+func (r *Recorder) Write(path string, err error) error {
+	data, marshalErr := json.MarshalIndent(r.Report(err), "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
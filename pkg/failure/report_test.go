@@ -0,0 +1,200 @@
+package failure_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/errors"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/failure"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/buildpacks/lifecycle/buildpack"
+	lifecycleLog "github.com/buildpacks/lifecycle/log"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeBuildExecutor struct {
+	output string
+	err    error
+}
+
+func (e fakeBuildExecutor) Build(d buildpack.BpDescriptor, inputs buildpack.BuildInputs, logger lifecycleLog.Logger) (buildpack.BuildOutputs, error) {
+	if _, err := fmt.Fprint(inputs.Out, e.output); err != nil {
+		return buildpack.BuildOutputs{}, err
+	}
+	return buildpack.BuildOutputs{}, e.err
+}
+
+type fakeDetectExecutor struct {
+	outputs buildpack.DetectOutputs
+}
+
+func (e fakeDetectExecutor) Detect(d buildpack.Descriptor, inputs buildpack.DetectInputs, logger lifecycleLog.Logger) buildpack.DetectOutputs {
+	return e.outputs
+}
+
+func descriptor(id string) *buildpack.BpDescriptor {
+	return &buildpack.BpDescriptor{Buildpack: buildpack.BpInfo{BaseInfo: buildpack.BaseInfo{ID: id, Version: "1.0.0"}}}
+}
+
+var _ = Describe("Recorder", func() {
+	var (
+		recorder *failure.Recorder
+		logger   *log.Logger
+	)
+
+	BeforeEach(func() {
+		recorder = failure.NewRecorder(2)
+		logger = log.New(GinkgoWriter)
+		logger.OnError(recorder.Error)
+	})
+
+	build := func(output string, err error) {
+		executor := recorder.BuildExecutor(fakeBuildExecutor{output: output, err: err})
+		_, _ = executor.Build(*descriptor("some/buildpack"), buildpack.BuildInputs{Out: GinkgoWriter, Err: GinkgoWriter}, logger)
+	}
+
+	It("reports the phase, exit code and logged errors", func() {
+		logger.Errorf("failed to set database URL, error: %s\n", "invalid scheme")
+
+		Expect(recorder.Report(errors.ErrGenericBuild)).To(Equal(failure.Report{
+			Phase:    errors.PhasePreparing,
+			ExitCode: 231,
+			Error:    "generic build failure",
+			Causes:   []string{"failed to set database URL, error: invalid scheme"},
+			Chain:    []failure.ChainError{{Error: "generic build failure"}},
+		}))
+	})
+
+	It("reports the buildpack that failed to build with its exit code and last output lines", func() {
+		recorder.Phase("BUILDING")
+		exitErr := exec.Command("sh", "-c", "exit 3").Run()
+		build("first\nsecond\nthird\nno newline", buildpack.NewError(exitErr, buildpack.ErrTypeBuildpack))
+		logger.Errorf("failed 'build' phase, error: %s\n", exitErr.Error())
+
+		Expect(recorder.Report(errors.ErrBuilding)).To(Equal(failure.Report{
+			Phase:    "building",
+			ExitCode: 234,
+			Error:    "building failed",
+			Causes:   []string{"failed 'build' phase, error: exit status 3"},
+			Chain:    []failure.ChainError{{Error: "building failed"}},
+			Buildpack: &failure.Buildpack{
+				ID:       "some/buildpack",
+				Version:  "1.0.0",
+				ExitCode: 3,
+				Output:   []string{"third", "no newline"},
+			},
+		}))
+	})
+
+	It("reports -1 as exit code when the buildpack did not exit with an error", func() {
+		recorder.Phase("BUILDING")
+		build("", fmt.Errorf("invalid launch.toml"))
+
+		Expect(recorder.Report(errors.ErrBuilding).Buildpack.ExitCode).To(Equal(-1))
+	})
+
+	It("does not report buildpacks that built", func() {
+		recorder.Phase("BUILDING")
+		build("done\n", nil)
+
+		Expect(recorder.Report(errors.ErrBuilding).Buildpack).To(BeNil())
+	})
+
+	It("reports the buildpack whose detect errored", func() {
+		recorder.Phase("DETECTING")
+		executor := recorder.DetectExecutor(fakeDetectExecutor{outputs: buildpack.DetectOutputs{Code: 127, Output: []byte("bin/detect: not found\n")}})
+		executor.Detect(descriptor("some/buildpack"), buildpack.DetectInputs{}, logger)
+
+		Expect(recorder.Report(errors.ErrDetecting).Buildpack).To(Equal(&failure.Buildpack{
+			ID:       "some/buildpack",
+			Version:  "1.0.0",
+			ExitCode: 127,
+			Output:   []string{"bin/detect: not found"},
+		}))
+	})
+
+	It("does not report buildpacks that failed to detect", func() {
+		recorder.Phase("DETECTING")
+		executor := recorder.DetectExecutor(fakeDetectExecutor{outputs: buildpack.DetectOutputs{Code: 100}})
+		executor.Detect(descriptor("some/buildpack"), buildpack.DetectInputs{}, logger)
+
+		Expect(recorder.Report(errors.ErrDetecting).Buildpack).To(BeNil())
+	})
+
+	It("forgets buildpack failures of earlier phases", func() {
+		recorder.Phase("DETECTING")
+		executor := recorder.DetectExecutor(fakeDetectExecutor{outputs: buildpack.DetectOutputs{Code: 1}})
+		executor.Detect(descriptor("optional/buildpack"), buildpack.DetectInputs{}, logger)
+		recorder.Phase("RESTORING")
+
+		report := recorder.Report(errors.ErrRestoring)
+		Expect(report.Phase).To(Equal("restoring"))
+		Expect(report.Buildpack).To(BeNil())
+	})
+
+	It("masks secrets", func() {
+		log.AddSecrets("report-secret")
+		recorder.Phase("BUILDING")
+		build("token report-secret\n", fmt.Errorf("failed"))
+		logger.Error("using report-secret")
+
+		report := recorder.Report(errors.ErrBuilding)
+		Expect(report.Causes).To(Equal([]string{"using [REDACTED]"}))
+		Expect(report.Buildpack.Output).To(Equal([]string{"token [REDACTED]"}))
+	})
+
+	It("reports the phase and cause of staging errors", func() {
+		recorder.Phase(errors.PhaseBuilding)
+		build("", fmt.Errorf("exit status 2"))
+		err := errors.Wrap(errors.ErrBuilding, errors.PhaseBuilding, fmt.Errorf("exit status 2")).WithBuildpack(recorder.Buildpack())
+		recorder.Phase(errors.PhaseExporting)
+
+		report := recorder.Report(err)
+		Expect(report.Phase).To(Equal(errors.PhaseBuilding))
+		Expect(report.ExitCode).To(Equal(234))
+		Expect(report.Error).To(Equal("building failed: buildpack some/buildpack: exit status 2"))
+	})
+
+	It("reports the wrapped causes of the error even if nothing was logged", func() {
+		log.AddSecrets("chain-secret")
+		cause := fmt.Errorf("running bin/build with chain-secret: %w", os.ErrPermission)
+		err := fmt.Errorf("staging: %w", errors.Wrap(errors.ErrBuilding, errors.PhaseBuilding, cause).WithBuildpack("some/buildpack"))
+
+		report := recorder.Report(err)
+		Expect(report.Causes).To(BeEmpty())
+		Expect(report.Chain).To(Equal([]failure.ChainError{
+			{Error: "staging: building failed: buildpack some/buildpack: running bin/build with [REDACTED]: permission denied"},
+			{
+				Error:     "building failed: buildpack some/buildpack: running bin/build with [REDACTED]: permission denied",
+				Kind:      "building failed",
+				Phase:     errors.PhaseBuilding,
+				Buildpack: "some/buildpack",
+			},
+			{Error: "running bin/build with [REDACTED]: permission denied"},
+			{Error: "permission denied"},
+		}))
+	})
+
+	It("reports the secret store error joined to the cause", func() {
+		err := fmt.Errorf("unable to interpolate: %w: %w", errors.ErrSecretUnavailable, fmt.Errorf("connection refused"))
+
+		Expect(recorder.Report(err).Chain).To(Equal([]failure.ChainError{
+			{Error: "unable to interpolate: secret store unavailable: connection refused"},
+			{Error: "secret store unavailable"},
+			{Error: "connection refused"},
+		}))
+	})
+
+	It("writes the report as JSON", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "failure.json")
+		Expect(recorder.Write(path, errors.ErrDownloadingBuildpack)).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{"phase":"preparing","exit_code":232,"error":"downloading buildpacks failed","chain":[{"error":"downloading buildpacks failed"}]}`))
+	})
+})
@@ -0,0 +1,58 @@
+package failure
+
+import (
+	"bytes"
+	"sync"
+)
+
+// tail keeps the last lines written to it
+type tail struct {
+	mu      sync.Mutex
+	size    int
+	lines   []string
+	partial []byte
+}
+
+func newTail(size int) *tail {
+	return &tail{size: max(size, 0)}
+}
+
+func (t *tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		line, rest, ok := bytes.Cut(t.partial, []byte("\n"))
+		if !ok {
+			break
+		}
+		t.add(string(bytes.TrimSuffix(line, []byte("\r"))))
+		t.partial = rest
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the last lines including an incomplete one
+func (t *tail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string{}, t.lines...)
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+	}
+	if len(lines) > t.size {
+		lines = lines[len(lines)-t.size:]
+	}
+
+	return lines
+}
+
+func (t *tail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.size {
+		t.lines = t.lines[len(t.lines)-t.size:]
+	}
+}
@@ -0,0 +1,99 @@
+package keychain
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	maxTokenSize = 64 * 1024
+	// defaultTokenLifetime applies to challenge tokens without expires_in, as in the registry token spec
+	defaultTokenLifetime = 60 * time.Second
+)
+
+// parseChallenge parses a WWW-Authenticate header like Bearer realm="https://auth.example.com/token",service="example.com"
+func parseChallenge(header string) (*transport.Challenge, bool) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "bearer") {
+		return nil, false
+	}
+
+	challenge := &transport.Challenge{Scheme: scheme, Parameters: map[string]string{}}
+	for params = strings.TrimSpace(params); params != ""; {
+		var key, value string
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			value, params = params[1:end+1], params[end+2:]
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		params = strings.TrimLeft(strings.TrimSpace(params), ", ")
+
+		challenge.Parameters[key] = value
+	}
+
+	_, ok := challenge.Parameters["realm"]
+	return challenge, ok
+}
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// tokenCache keeps the tokens fetched for challenges per host and credentials for the lifetime of the client
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+func (c *tokenCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.tokens[key]
+	if !ok || time.Now().After(cached.expiry) {
+		return "", false
+	}
+	return cached.token, true
+}
+
+func (c *tokenCache) set(key string, token string, expiresIn int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lifetime := defaultTokenLifetime
+	if expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	if c.tokens == nil {
+		c.tokens = map[string]cachedToken{}
+	}
+	log.AddSecrets(token)
+	c.tokens[key] = cachedToken{token: token, expiry: time.Now().Add(lifetime - tokenExpiryMargin)}
+}
+
+// exchange fetches a token for a challenge from its realm, authenticating with the credentials of the request
+func exchange(ctx context.Context, req *http.Request, authenticator authn.Authenticator, inner http.RoundTripper, challenge *transport.Challenge) (*transport.Token, error) {
+	reg, err := name.NewRegistry(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge.Insecure = req.URL.Scheme == "http"
+	return transport.Exchange(ctx, reg, authenticator, inner, strings.Fields(challenge.Parameters["scope"]), challenge)
+}
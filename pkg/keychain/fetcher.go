@@ -0,0 +1,73 @@
+package keychain
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/layout"
+	"github.com/buildpacks/pack/pkg/buildpack"
+	"github.com/buildpacks/pack/pkg/image"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+var _ buildpack.ImageFetcher = (*imageFetcher)(nil)
+
+// imageFetcher fetches registry images with the keychain scoped to their repository and the given transport
+type imageFetcher struct {
+	keychain  authn.Keychain
+	transport http.RoundTripper
+}
+
+// NewImageFetcher returns an image fetcher that resolves credentials for the repository of each image and sends
+// its requests with transport. pack and imgutil resolve credentials for the registry only, which never matches path
+// scoped credential keys, and always use http.DefaultTransport.
+func NewImageFetcher(keychain authn.Keychain, transport http.RoundTripper) buildpack.ImageFetcher {
+	return &imageFetcher{keychain: keychain, transport: transport}
+}
+
+func (f *imageFetcher) Fetch(ctx context.Context, imageName string, options image.FetchOptions) (imgutil.Image, error) {
+	ref, err := name.ParseReference(imageName, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteOptions := f.options(ctx)
+	if options.Target != nil {
+		remoteOptions = append(remoteOptions, remote.WithPlatform(v1.Platform{
+			OS:           options.Target.OS,
+			Architecture: options.Target.Arch,
+			Variant:      options.Target.ArchVariant,
+		}))
+	}
+
+	img, err := remote.Image(ref, remoteOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.NewImage("", layout.FromBaseImageInstance(img))
+}
+
+func (f *imageFetcher) CheckReadAccess(repo string, _ image.FetchOptions) bool {
+	ref, err := name.ParseReference(repo, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+
+	_, err = remote.Head(ref, f.options(context.Background())...)
+	return err == nil
+}
+
+func (f *imageFetcher) options(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(f.keychain),
+		remote.WithTransport(f.transport),
+		// the transport retries failed requests itself, retrying them here as well would multiply the attempts
+		remote.WithRetryPredicate(func(error) bool { return false }),
+	}
+}
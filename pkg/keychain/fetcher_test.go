@@ -0,0 +1,133 @@
+package keychain_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/keychain"
+	"github.com/buildpacks/pack/pkg/image"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/jarcoal/httpmock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// countingTransport counts the requests it sends
+type countingTransport struct {
+	inner    http.RoundTripper
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return t.inner.RoundTrip(req)
+}
+
+var _ = Describe("NewImageFetcher", func() {
+	var (
+		server      *httptest.Server
+		host        string
+		username    string
+		transport   *countingTransport
+		unavailable atomic.Int32
+	)
+
+	BeforeEach(func() {
+		manifest, err := empty.Image.RawManifest()
+		Expect(err).ToNot(HaveOccurred())
+		config, err := empty.Image.RawConfigFile()
+		Expect(err).ToNot(HaveOccurred())
+		configName, err := empty.Image.ConfigName()
+		Expect(err).ToNot(HaveOccurred())
+		mediaType, err := empty.Image.MediaType()
+		Expect(err).ToNot(HaveOccurred())
+		digest, err := empty.Image.Digest()
+		Expect(err).ToNot(HaveOccurred())
+
+		username = ""
+		unavailable.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v2/" {
+				return
+			}
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "team" || password != "team-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			username = user
+
+			switch r.URL.Path {
+			case "/v2/team/unavailable/manifests/latest":
+				unavailable.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case "/v2/team/buildpack/manifests/latest":
+				w.Header().Set("Content-Type", string(mediaType))
+				w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+				w.Header().Set("Docker-Content-Digest", digest.String())
+				_, _ = w.Write(manifest)
+			case "/v2/team/buildpack/blobs/" + configName.String():
+				_, _ = w.Write(config)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		host = strings.TrimPrefix(server.URL, "http://")
+		// the default transport is mocked in this suite, registry requests must only go through the given transport
+		transport = &countingTransport{inner: httpmock.InitialTransport}
+
+		GinkgoT().Setenv(keychain.CnbCredentialsEnv, `{
+			"`+host+`":{"username":"other","password":"other-secret"},
+			"`+host+`/team":{"username":"team","password":"team-secret"}
+		}`)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("fetches images with the credentials scoped to their repository", func() {
+		kc, err := keychain.FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+
+		fetcher := keychain.NewImageFetcher(kc, transport)
+		img, err := fetcher.Fetch(context.Background(), host+"/team/buildpack:latest", image.FetchOptions{Daemon: false})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(img.Label("io.buildpacks.buildpackage.metadata")).To(BeEmpty())
+		Expect(username).To(Equal("team"))
+		Expect(transport.requests).To(BeNumerically(">", 0))
+	})
+
+	It("uses the registry credentials outside of the scoped path", func() {
+		kc, err := keychain.FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+
+		fetcher := keychain.NewImageFetcher(kc, transport)
+		_, err = fetcher.Fetch(context.Background(), host+"/other/buildpack:latest", image.FetchOptions{Daemon: false})
+		Expect(err).To(HaveOccurred())
+		Expect(username).To(BeEmpty())
+	})
+
+	It("leaves retries to the transport", func() {
+		kc, err := keychain.FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+
+		fetcher := keychain.NewImageFetcher(kc, transport)
+		_, err = fetcher.Fetch(context.Background(), host+"/team/unavailable:latest", image.FetchOptions{Daemon: false})
+		Expect(err).To(HaveOccurred())
+		Expect(unavailable.Load()).To(Equal(int32(1)))
+	})
+
+	It("checks read access with the credentials scoped to the repository", func() {
+		kc, err := keychain.FromEnv()
+		Expect(err).ToNot(HaveOccurred())
+
+		fetcher := keychain.NewImageFetcher(kc, transport)
+		Expect(fetcher.CheckReadAccess(host+"/team/buildpack:latest", image.FetchOptions{})).To(BeTrue())
+		Expect(fetcher.CheckReadAccess(host+"/other/buildpack:latest", image.FetchOptions{})).To(BeFalse())
+	})
+})
@@ -4,15 +4,21 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
 
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
 	"github.com/google/go-containerregistry/pkg/authn"
 )
 
-func NewHTTPClient(keychain authn.Keychain) *http.Client {
+// NewHTTPClient returns a client authenticating the requests it sends with transport with the credentials of the
+// keychain
+func NewHTTPClient(keychain authn.Keychain, transport http.RoundTripper, logger *log.Logger) *http.Client {
 	return &http.Client{
 		Transport: &roundTripper{
 			keychain: keychain,
-			inner:    http.DefaultTransport,
+			inner:    transport,
+			tokens:   &tokenCache{},
+			logger:   logger,
 		},
 	}
 }
@@ -20,6 +26,10 @@ func NewHTTPClient(keychain authn.Keychain) *http.Client {
 type roundTripper struct {
 	keychain authn.Keychain
 	inner    http.RoundTripper
+	tokens   *tokenCache
+	logger   *log.Logger
+	// logged keeps the credential sources already logged per host, a download sends many requests
+	logged sync.Map
 }
 
 func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
@@ -32,22 +42,86 @@ func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
-	if authenticator == authn.Anonymous {
-		return rt.inner.RoundTrip(req)
+	authReq := req.Clone(req.Context())
+
+	// credentials of the original host never follow a redirect to another host, the target host is only sent
+	// the credentials resolved for it
+	from := redirectedFrom(req)
+	if from != "" && from != req.URL.Host {
+		authReq.Header.Del("Authorization")
+	}
+
+	if authenticator != authn.Anonymous {
+		source := credentialSource(authenticator)
+		if _, ok := rt.logged.LoadOrStore(req.URL.Host+" "+source, true); !ok {
+			rt.logger.Infof("Using credentials %s for %s", source, req.URL.Host)
+		}
+	} else if from != "" && from != req.URL.Host {
+		rt.logger.Debugf("Sending no credentials to %s after the redirect from %s", req.URL.Host, from)
+	}
+
+	// tokens are cached per credentials, the credentials of one path of the host may be granted more than another's
+	tokenKey := req.URL.Host + " " + credentialSource(authenticator)
+	if token, ok := rt.tokens.get(tokenKey); ok {
+		authReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	} else if authenticator != authn.Anonymous {
+		if err := setAuthorization(authReq, authenticator); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := rt.inner.RoundTrip(authReq)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// like the registry client, exchange the credentials for a token when challenged
+	challenge, ok := parseChallenge(res.Header.Get("WWW-Authenticate"))
+	if !ok || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return res, nil
+	}
+	res.Body.Close()
+
+	token, err := exchange(req.Context(), req, authenticator, rt.inner, challenge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token for %s: %w", req.URL.Host, err)
+	}
+	bearer := token.Token
+	if bearer == "" {
+		bearer = token.AccessToken
 	}
+	rt.tokens.set(tokenKey, bearer, token.ExpiresIn)
+
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retryReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retryReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearer))
+	return rt.inner.RoundTrip(retryReq)
+}
 
+// redirectedFrom returns the host that redirected to the request, if any
+func redirectedFrom(req *http.Request) string {
+	if req.Response == nil || req.Response.Request == nil {
+		return ""
+	}
+	return req.Response.Request.URL.Host
+}
+
+func setAuthorization(req *http.Request, authenticator authn.Authenticator) error {
 	conf, err := authenticator.Authorization()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if conf.RegistryToken != "" {
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", conf.RegistryToken))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", conf.RegistryToken))
 	} else {
 		req.SetBasicAuth(conf.Username, conf.Password)
 	}
-
-	return rt.inner.RoundTrip(req)
+	return nil
 }
 
 type urlResource struct {
@@ -55,9 +129,9 @@ type urlResource struct {
 }
 
 func (r *urlResource) RegistryStr() string {
-	return r.url.Hostname()
+	return r.url.Host
 }
 
 func (r *urlResource) String() string {
-	return r.RegistryStr()
+	return r.RegistryStr() + r.url.Path
 }
@@ -6,8 +6,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/keychain"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/jarcoal/httpmock"
 	. "github.com/onsi/ginkgo/v2"
@@ -24,7 +26,7 @@ var _ = Describe("HTTP RoundTripper", func() {
 	BeforeEach(func() {
 		creds, err = keychain.FromEnv()
 		Expect(err).ToNot(HaveOccurred())
-		client = keychain.NewHTTPClient(creds)
+		client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.NewLogger())
 	})
 
 	Describe("RoundTrip", func() {
@@ -36,7 +38,7 @@ var _ = Describe("HTTP RoundTripper", func() {
 				return httpmock.NewStringResponse(http.StatusOK, ""), nil
 			})
 
-			client = keychain.NewHTTPClient(nil)
+			client = keychain.NewHTTPClient(nil, http.DefaultTransport, log.NewLogger())
 
 			res, err := client.Get("https://test.io")
 			Expect(err).ToNot(HaveOccurred())
@@ -62,7 +64,7 @@ var _ = Describe("HTTP RoundTripper", func() {
 				Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"bearer.test":{"token":"foo"},"basic.test":{"username":"foo","password":"bar"}}`)).To(Succeed())
 				creds, err = keychain.FromEnv()
 				Expect(err).ToNot(HaveOccurred())
-				client = keychain.NewHTTPClient(creds)
+				client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.NewLogger())
 			})
 
 			AfterEach(func() {
@@ -110,6 +112,252 @@ var _ = Describe("HTTP RoundTripper", func() {
 				Expect(err).ToNot(HaveOccurred())
 				Expect(body.String()).To(Equal("Basic Zm9vOmJhcg=="))
 			})
+
+			It("logs the credentials used once per host", func() {
+				httpmock.RegisterNoResponder(httpmock.NewStringResponder(http.StatusOK, ""))
+				out := &bytes.Buffer{}
+				client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.New(out))
+
+				for _, url := range []string{"https://basic.test/bp1.tgz", "https://basic.test/bp2.tgz", "https://bearer.test/bp.tgz"} {
+					res, err := client.Get(url)
+					Expect(err).ToNot(HaveOccurred())
+					res.Body.Close()
+				}
+
+				Expect(strings.Count(out.String(), "Using credentials")).To(Equal(2))
+				Expect(out.String()).To(ContainSubstring("for basic.test"))
+				Expect(out.String()).To(ContainSubstring("for bearer.test"))
+			})
+		})
+	})
+
+	Describe("scoped credentials", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"*.artifactory.test":{"token":"wildcard"},"repo.artifactory.test:8443/team-a/":{"token":"team-a"}}`)).To(Succeed())
+			creds, err = keychain.FromEnv()
+			Expect(err).ToNot(HaveOccurred())
+			client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.NewLogger())
+
+			httpmock.RegisterNoResponder(func(r *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(http.StatusOK, r.Header.Get("Authorization")), nil
+			})
+		})
+
+		AfterEach(func() {
+			Expect(os.Unsetenv(keychain.CnbCredentialsEnv)).To(Succeed())
+		})
+
+		DescribeTable("sends the most specific credentials",
+			func(url string, authorization string) {
+				res, err := client.Get(url)
+				Expect(err).ToNot(HaveOccurred())
+				defer res.Body.Close()
+
+				body, err := io.ReadAll(res.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(Equal(authorization))
+			},
+			Entry("wildcard host", "https://repo.artifactory.test/team-b/bp.tgz", "Bearer wildcard"),
+			Entry("port and path prefix", "https://repo.artifactory.test:8443/team-a/bp.tgz", "Bearer team-a"),
+			Entry("path prefix on another port", "https://repo.artifactory.test/team-a/bp.tgz", "Bearer wildcard"),
+			Entry("no match", "https://artifactory.test/bp.tgz", ""),
+		)
+	})
+
+	Describe("token authentication", func() {
+		var tokenRequests int
+
+		BeforeEach(func() {
+			tokenRequests = 0
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{
+				"oauth.test": {"client_id": "id", "client_secret": "secret", "token_url": "https://auth.test/oauth/token", "scopes": ["read", "list"]},
+				"challenge.test": {"username": "user", "password": "pass"},
+				"scoped.test/team-a/": {"username": "team-a", "password": "pass-a"},
+				"scoped.test/team-b/": {"username": "team-b", "password": "pass-b"}
+			}`)).To(Succeed())
+			creds, err = keychain.FromEnv()
+			Expect(err).ToNot(HaveOccurred())
+			client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.NewLogger())
+		})
+
+		AfterEach(func() {
+			Expect(os.Unsetenv(keychain.CnbCredentialsEnv)).To(Succeed())
+		})
+
+		get := func(url string) (int, string) {
+			res, err := client.Get(url)
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return res.StatusCode, string(body)
+		}
+
+		It("exchanges client credentials for a cached token", func() {
+			httpmock.RegisterResponder("POST", "https://auth.test/oauth/token", func(r *http.Request) (*http.Response, error) {
+				tokenRequests++
+				username, password, ok := r.BasicAuth()
+				if !ok || username != "id" || password != "secret" {
+					return httpmock.NewStringResponse(http.StatusUnauthorized, ""), nil
+				}
+				Expect(r.ParseForm()).To(Succeed())
+				Expect(r.PostForm.Get("grant_type")).To(Equal("client_credentials"))
+				Expect(r.PostForm.Get("scope")).To(Equal("read list"))
+				return httpmock.NewStringResponse(http.StatusOK, `{"access_token": "oauth-token", "token_type": "bearer", "expires_in": 3600}`), nil
+			})
+			httpmock.RegisterNoResponder(func(r *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(http.StatusOK, r.Header.Get("Authorization")), nil
+			})
+
+			_, body := get("https://oauth.test/bp1.tgz")
+			Expect(body).To(Equal("Bearer oauth-token"))
+			_, body = get("https://oauth.test/bp2.tgz")
+			Expect(body).To(Equal("Bearer oauth-token"))
+			Expect(tokenRequests).To(Equal(1))
+		})
+
+		It("fails when the token request fails", func() {
+			httpmock.RegisterResponder("POST", "https://auth.test/oauth/token", httpmock.NewStringResponder(http.StatusForbidden, ""))
+
+			_, err := client.Get("https://oauth.test/bp.tgz")
+			Expect(err).To(MatchError(ContainSubstring("failed to request token from https://auth.test/oauth/token: 403")))
+		})
+
+		It("fetches a token when challenged", func() {
+			challenges := 0
+			httpmock.RegisterResponder("GET", "https://challenge.test/bp.tgz", func(r *http.Request) (*http.Response, error) {
+				if r.Header.Get("Authorization") == "Bearer challenge-token" {
+					return httpmock.NewStringResponse(http.StatusOK, "buildpack"), nil
+				}
+				challenges++
+				res := httpmock.NewStringResponse(http.StatusUnauthorized, "")
+				res.Header.Set("WWW-Authenticate", `Bearer realm="https://auth.test/token",service="challenge.test",scope="repository:bp:pull,push"`)
+				return res, nil
+			})
+			httpmock.RegisterResponder("GET", "https://auth.test/token", func(r *http.Request) (*http.Response, error) {
+				tokenRequests++
+				username, password, ok := r.BasicAuth()
+				if !ok || username != "user" || password != "pass" {
+					return httpmock.NewStringResponse(http.StatusUnauthorized, ""), nil
+				}
+				Expect(r.URL.Query().Get("service")).To(Equal("challenge.test"))
+				Expect(r.URL.Query().Get("scope")).To(Equal("repository:bp:pull,push"))
+				return httpmock.NewStringResponse(http.StatusOK, `{"token": "challenge-token"}`), nil
+			})
+
+			status, body := get("https://challenge.test/bp.tgz")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("buildpack"))
+
+			status, _ = get("https://challenge.test/bp.tgz")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(challenges).To(Equal(1))
+			Expect(tokenRequests).To(Equal(1))
+		})
+
+		It("caches the tokens of path-scoped credentials separately", func() {
+			httpmock.RegisterResponder("GET", "https://auth.test/token", func(r *http.Request) (*http.Response, error) {
+				tokenRequests++
+				username, _, _ := r.BasicAuth()
+				return httpmock.NewStringResponse(http.StatusOK, fmt.Sprintf(`{"token": "token-%s"}`, username)), nil
+			})
+			httpmock.RegisterNoResponder(func(r *http.Request) (*http.Response, error) {
+				if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+					return httpmock.NewStringResponse(http.StatusOK, r.Header.Get("Authorization")), nil
+				}
+				res := httpmock.NewStringResponse(http.StatusUnauthorized, "")
+				res.Header.Set("WWW-Authenticate", `Bearer realm="https://auth.test/token",service="scoped.test"`)
+				return res, nil
+			})
+
+			_, body := get("https://scoped.test/team-a/bp.tgz")
+			Expect(body).To(Equal("Bearer token-team-a"))
+			_, body = get("https://scoped.test/team-b/bp.tgz")
+			Expect(body).To(Equal("Bearer token-team-b"))
+			_, body = get("https://scoped.test/team-a/other.tgz")
+			Expect(body).To(Equal("Bearer token-team-a"))
+			Expect(tokenRequests).To(Equal(2))
+		})
+
+		It("returns the response for other challenges", func() {
+			httpmock.RegisterResponder("GET", "https://challenge.test/bp.tgz", func(r *http.Request) (*http.Response, error) {
+				res := httpmock.NewStringResponse(http.StatusUnauthorized, "")
+				res.Header.Set("WWW-Authenticate", `Basic realm="artifacts"`)
+				return res, nil
+			})
+
+			status, _ := get("https://challenge.test/bp.tgz")
+			Expect(status).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Describe("redirects", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"origin.test":{"token":"origin"},"mirror.test":{"token":"mirror"}}`)).To(Succeed())
+			creds, err = keychain.FromEnv()
+			Expect(err).ToNot(HaveOccurred())
+			client = keychain.NewHTTPClient(creds, http.DefaultTransport, log.NewLogger())
+
+			httpmock.RegisterNoResponder(func(r *http.Request) (*http.Response, error) {
+				return httpmock.NewStringResponse(http.StatusOK, r.Header.Get("Authorization")), nil
+			})
+		})
+
+		AfterEach(func() {
+			Expect(os.Unsetenv(keychain.CnbCredentialsEnv)).To(Succeed())
+		})
+
+		redirect := func(from, to string) {
+			httpmock.RegisterResponder("GET", from, func(r *http.Request) (*http.Response, error) {
+				res := httpmock.NewStringResponse(http.StatusFound, "")
+				res.Header.Set("Location", to)
+				res.Request = r
+				return res, nil
+			})
+		}
+
+		get := func(req *http.Request) string {
+			res, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return string(body)
+		}
+
+		It("does not send the credentials to another host", func() {
+			redirect("https://origin.test/bp.tgz", "https://cdn.test/bp.tgz")
+
+			req, err := http.NewRequest("GET", "https://origin.test/bp.tgz", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(get(req)).To(BeEmpty())
+		})
+
+		It("sends the credentials of the target host", func() {
+			redirect("https://origin.test/bp.tgz", "https://mirror.test/bp.tgz")
+
+			req, err := http.NewRequest("GET", "https://origin.test/bp.tgz", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(get(req)).To(Equal("Bearer mirror"))
+		})
+
+		It("keeps the credentials on the same host", func() {
+			redirect("https://origin.test/bp.tgz", "https://origin.test/v2/bp.tgz")
+
+			req, err := http.NewRequest("GET", "https://origin.test/bp.tgz", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(get(req)).To(Equal("Bearer origin"))
+		})
+
+		It("strips authorization headers when redirected to a subdomain", func() {
+			redirect("https://example.test/bp.tgz", "https://cdn.example.test/bp.tgz")
+
+			req, err := http.NewRequest("GET", "https://example.test/bp.tgz", nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("Authorization", "Bearer caller")
+			Expect(get(req)).To(BeEmpty())
 		})
 	})
 })
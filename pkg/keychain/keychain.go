@@ -1,19 +1,49 @@
 package keychain
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
 	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
 )
 
-const CnbCredentialsEnv = "CNB_REGISTRY_CREDS"
+const (
+	CnbCredentialsEnv = "CNB_REGISTRY_CREDS"
+	// DockerConfigEnv is the path of a docker config.json
+	DockerConfigEnv = "CNB_REGISTRY_DOCKER_CONFIG"
+	// SecretsDirEnv is a directory of mounted kubernetes.io/dockerconfigjson secrets
+	SecretsDirEnv = "CNB_REGISTRY_SECRETS_DIR"
+	// CredentialsDirEnv is a directory with one credentials file per registry
+	CredentialsDirEnv = "CNB_REGISTRY_CREDS_DIR"
+
+	dockerConfigJSONKey = ".dockerconfigjson"
+)
 
 type auth struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 	Token    string `json:"token,omitempty"`
+	// ClientID, ClientSecret and TokenURL exchange OAuth2 client credentials for a token
+	ClientID     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	TokenURL     string   `json:"token_url,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// source describes where the credentials were read from, without secrets
+	source string
+}
+
+func (a auth) isOAuth2() bool {
+	return a.ClientID != "" && a.ClientSecret != "" && a.TokenURL != "" && a.Username == "" && a.Password == "" && a.Token == ""
 }
 
 func (a auth) config() (authn.AuthConfig, error) {
@@ -34,33 +64,245 @@ func (a auth) config() (authn.AuthConfig, error) {
 }
 
 type envKeyChain struct {
-	credentials map[string]auth
+	credentials []credential
+}
+
+// Sources are the locations registry credentials are loaded from in addition to CNB_REGISTRY_CREDS
+type Sources struct {
+	DockerConfig   string
+	SecretsDir     string
+	CredentialsDir string
+	// Transport sends the token requests of OAuth2 credentials, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+func SourcesFromEnv() Sources {
+	return Sources{
+		DockerConfig:   os.Getenv(DockerConfigEnv),
+		SecretsDir:     os.Getenv(SecretsDirEnv),
+		CredentialsDir: os.Getenv(CredentialsDirEnv),
+	}
 }
 
 func FromEnv() (authn.Keychain, error) {
-	value, ok := os.LookupEnv(CnbCredentialsEnv)
-	if !ok {
-		return authn.DefaultKeychain, nil
+	return SourcesFromEnv().Keychain()
+}
+
+// Keychain merges the credentials of all sources, for the same registry CNB_REGISTRY_CREDS takes precedence over
+// the per-registry files, the dockerconfigjson secrets and the docker config, in this order
+func (s Sources) Keychain() (authn.Keychain, error) {
+	merged := map[string]auth{}
+	loaders := []func() (map[string]auth, error){
+		func() (map[string]auth, error) { return readDockerConfig(s.DockerConfig) },
+		func() (map[string]auth, error) { return readSecrets(s.SecretsDir) },
+		func() (map[string]auth, error) { return readCredentialFiles(s.CredentialsDir) },
+		readEnv,
+	}
+	for _, load := range loaders {
+		credentials, err := load()
+		if err != nil {
+			return nil, err
+		}
+		for registry, creds := range credentials {
+			merged[registry] = creds
+		}
+	}
+	for _, creds := range merged {
+		log.AddSecrets(creds.Password, creds.Token, creds.ClientSecret)
 	}
 
-	e := &envKeyChain{}
-	if err := json.Unmarshal([]byte(value), &e.credentials); err != nil {
-		return nil, err
+	if len(merged) == 0 {
+		if _, ok := os.LookupEnv(CnbCredentialsEnv); !ok {
+			return authn.DefaultKeychain, nil
+		}
 	}
 
-	return authn.NewMultiKeychain(e, authn.DefaultKeychain), nil
+	return authn.NewMultiKeychain(&envKeyChain{credentials: newCredentials(merged, s.Transport)}, authn.DefaultKeychain), nil
 }
 
 func (e *envKeyChain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
-	creds, ok := e.credentials[resource.RegistryStr()]
+	creds, ok := match(e.credentials, resource)
 	if !ok {
 		return authn.Anonymous, nil
 	}
 
-	config, err := creds.config()
+	source := fmt.Sprintf("%q from %s", creds.key, creds.auth.source)
+	if creds.oauth2 != nil {
+		return &sourcedAuthenticator{Authenticator: creds.oauth2, source: source}, nil
+	}
+
+	config, err := creds.auth.config()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sourcedAuthenticator{Authenticator: authn.FromConfig(config), source: source}, nil
+}
+
+// sourcedAuthenticator records which credentials were resolved for logging
+type sourcedAuthenticator struct {
+	authn.Authenticator
+	source string
+}
+
+// credentialSource describes the credentials of an authenticator resolved by a keychain
+func credentialSource(authenticator authn.Authenticator) string {
+	switch a := authenticator.(type) {
+	case *sourcedAuthenticator:
+		return a.source
+	case nil:
+		return "no credentials"
+	}
+	if authenticator == authn.Anonymous {
+		return "no credentials"
+	}
+	return "the default keychain"
+}
+
+func readEnv() (map[string]auth, error) {
+	value, ok := os.LookupEnv(CnbCredentialsEnv)
+	if !ok {
+		return nil, nil
+	}
+
+	var credentials map[string]auth
+	if err := json.Unmarshal([]byte(value), &credentials); err != nil {
+		return nil, err
+	}
+	for registry, creds := range credentials {
+		creds.source = CnbCredentialsEnv
+		credentials[registry] = creds
+	}
+
+	return credentials, nil
+}
+
+// readCredentialFiles reads files named after a registry containing its credentials in the CNB_REGISTRY_CREDS format
+func readCredentialFiles(dir string) (map[string]auth, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := map[string]auth{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var creds auth
+		if err := json.Unmarshal(content, &creds); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		creds.source = path
+		credentials[entry.Name()] = creds
+	}
+
+	return credentials, nil
+}
+
+// readSecrets reads mounted kubernetes.io/dockerconfigjson secrets, either a directory per secret or the
+// secret keys directly in dir, the secret sorting first wins for the same registry
+func readSecrets(dir string) (map[string]auth, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	paths := []string{filepath.Join(dir, dockerConfigJSONKey)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			paths = append(paths, filepath.Join(dir, entry.Name(), dockerConfigJSONKey))
+		}
+	}
+
+	credentials := map[string]auth{}
+	for _, path := range slices.Backward(paths) {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		secretCredentials, err := readDockerConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		for registry, creds := range secretCredentials {
+			credentials[registry] = creds
+		}
+	}
+
+	return credentials, nil
+}
+
+type dockerConfig struct {
+	Auths map[string]dockerAuth `json:"auths"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+func readDockerConfig(path string) (map[string]auth, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return authn.FromConfig(config), nil
+	var config dockerConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	credentials := map[string]auth{}
+	for server, entry := range config.Auths {
+		creds := auth{Username: entry.Username, Password: entry.Password, Token: entry.RegistryToken, source: path}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode auth of %q in %s: %w", server, path, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid auth of %q in %s", server, path)
+			}
+			creds.Username, creds.Password = username, password
+		}
+		credentials[registryFromServer(server)] = creds
+	}
+
+	return credentials, nil
+}
+
+// registryFromServer turns a docker config server address like https://index.docker.io/v1/ into a registry name
+func registryFromServer(server string) string {
+	host := server
+	if _, after, ok := strings.Cut(host, "://"); ok {
+		host = after
+	}
+	host, _, _ = strings.Cut(host, "/")
+
+	if registry, err := name.NewRegistry(host); err == nil {
+		return registry.RegistryStr()
+	}
+	return host
 }
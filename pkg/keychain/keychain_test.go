@@ -2,8 +2,10 @@ package keychain_test
 
 import (
 	"os"
+	"path/filepath"
 
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/keychain"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	. "github.com/onsi/ginkgo/v2"
@@ -74,6 +76,7 @@ var _ = Describe("FromEnv", func() {
 			},
 			Entry("username and password", `{"registry.io":{"username":"username", "password":"password"}}`),
 			Entry("token", `{"registry.io":{"token":"token"}}`),
+			Entry("client credentials", `{"registry.io":{"client_id":"id", "client_secret":"secret", "token_url":"https://auth.io/token"}}`),
 		)
 
 		DescribeTable("credentials combinations (failure)",
@@ -93,6 +96,153 @@ var _ = Describe("FromEnv", func() {
 			Entry("password and token", `{"registry.io": {"password":"password", "token":"token"}}`),
 			Entry("only username", `{"registry.io":{"username":"username"}}`),
 			Entry("only password", `{"registry.io": {"password":"password"}}`),
+			Entry("client credentials without token url", `{"registry.io": {"client_id":"id", "client_secret":"secret"}}`),
+		)
+	})
+
+	Describe("Sources", func() {
+		var dir string
+
+		resolve := func(kc authn.Keychain, registry string) *authn.AuthConfig {
+			reg, err := name.NewRegistry(registry)
+			Expect(err).ToNot(HaveOccurred())
+			authentication, err := kc.Resolve(reg)
+			Expect(err).ToNot(HaveOccurred())
+			authorization, err := authentication.Authorization()
+			Expect(err).ToNot(HaveOccurred())
+			return authorization
+		}
+
+		writeFile := func(path, content string) string {
+			Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+			Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+			return path
+		}
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"env.io":{"token":"env"}}`)).To(Succeed())
+		})
+
+		It("reads a docker config.json", func() {
+			config := writeFile(filepath.Join(dir, "config.json"), `{"auths":{
+				"https://index.docker.io/v1/":{"auth":"dXNlcjpwYXNz"},
+				"config.io":{"username":"user","password":"pass"},
+				"token.io":{"registrytoken":"token"}
+			}}`)
+
+			kc, err := keychain.Sources{DockerConfig: config}.Keychain()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolve(kc, "docker.io")).To(BeEquivalentTo(&authn.AuthConfig{Username: "user", Password: "pass"}))
+			Expect(resolve(kc, "config.io")).To(BeEquivalentTo(&authn.AuthConfig{Username: "user", Password: "pass"}))
+			Expect(resolve(kc, "token.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "token"}))
+			Expect(resolve(kc, "env.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "env"}))
+		})
+
+		It("reads mounted dockerconfigjson secrets", func() {
+			writeFile(filepath.Join(dir, "a-secret", ".dockerconfigjson"), `{"auths":{"secret.io":{"username":"a","password":"a"}}}`)
+			writeFile(filepath.Join(dir, "b-secret", ".dockerconfigjson"), `{"auths":{"secret.io":{"username":"b","password":"b"},"other.io":{"username":"b","password":"b"}}}`)
+			writeFile(filepath.Join(dir, "not-a-secret", "username"), "ignored")
+
+			kc, err := keychain.Sources{SecretsDir: dir}.Keychain()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolve(kc, "secret.io")).To(BeEquivalentTo(&authn.AuthConfig{Username: "a", Password: "a"}))
+			Expect(resolve(kc, "other.io")).To(BeEquivalentTo(&authn.AuthConfig{Username: "b", Password: "b"}))
+		})
+
+		It("reads per-registry credential files", func() {
+			writeFile(filepath.Join(dir, "file.io"), `{"token":"file"}`)
+			writeFile(filepath.Join(dir, "..data"), `not json`)
+
+			kc, err := keychain.Sources{CredentialsDir: dir}.Keychain()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolve(kc, "file.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "file"}))
+		})
+
+		It("merges the sources by precedence", func() {
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"all.io":{"token":"env"}}`)).To(Succeed())
+			config := writeFile(filepath.Join(dir, "config.json"), `{"auths":{"all.io":{"registrytoken":"config"},"config.io":{"registrytoken":"config"},"secret.io":{"registrytoken":"config"},"file.io":{"registrytoken":"config"}}}`)
+			writeFile(filepath.Join(dir, "secrets", "s", ".dockerconfigjson"), `{"auths":{"all.io":{"registrytoken":"secret"},"secret.io":{"registrytoken":"secret"},"file.io":{"registrytoken":"secret"}}}`)
+			writeFile(filepath.Join(dir, "files", "all.io"), `{"token":"file"}`)
+			writeFile(filepath.Join(dir, "files", "file.io"), `{"token":"file"}`)
+
+			kc, err := keychain.Sources{
+				DockerConfig:   config,
+				SecretsDir:     filepath.Join(dir, "secrets"),
+				CredentialsDir: filepath.Join(dir, "files"),
+			}.Keychain()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolve(kc, "all.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "env"}))
+			Expect(resolve(kc, "file.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "file"}))
+			Expect(resolve(kc, "secret.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "secret"}))
+			Expect(resolve(kc, "config.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "config"}))
+		})
+
+		It("reads the sources from the environment", func() {
+			writeFile(filepath.Join(dir, "file.io"), `{"token":"file"}`)
+			GinkgoT().Setenv(keychain.CredentialsDirEnv, dir)
+
+			kc, err := keychain.FromEnv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolve(kc, "file.io")).To(BeEquivalentTo(&authn.AuthConfig{RegistryToken: "file"}))
+		})
+
+		It("masks the passwords, tokens and client secrets in the log output", func() {
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{"token.io":{"token":"masked-token"},"oauth.io":{"client_id":"id","client_secret":"masked-client-secret","token_url":"https://oauth.io/token"}}`)).To(Succeed())
+			writeFile(filepath.Join(dir, "file.io"), `{"username":"visible-name","password":"masked-password"}`)
+
+			_, err := keychain.Sources{CredentialsDir: dir}.Keychain()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(log.Redact("visible-name masked-password masked-token masked-client-secret")).To(Equal("visible-name [REDACTED] [REDACTED] [REDACTED]"))
+		})
+
+		It("fails for an invalid docker config", func() {
+			config := writeFile(filepath.Join(dir, "config.json"), `{"auths":{"config.io":{"auth":"invalid"}}}`)
+
+			_, err := keychain.Sources{DockerConfig: config}.Keychain()
+			Expect(err).To(MatchError(ContainSubstring(`failed to decode auth of "config.io"`)))
+		})
+
+		It("fails for a missing credentials dir", func() {
+			_, err := keychain.Sources{CredentialsDir: filepath.Join(dir, "missing")}.Keychain()
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+	})
+
+	Describe("matching", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv(keychain.CnbCredentialsEnv, `{
+				"*.example.com": {"token": "wildcard"},
+				"registry.example.com": {"token": "host"},
+				"registry.example.com:5000": {"token": "port"},
+				"registry.example.com/team-a": {"token": "team-a"},
+				"registry.example.com/team-a/app": {"token": "team-a-app"},
+				"*.*.example.com": {"token": "two-wildcards"}
+			}`)).To(Succeed())
+		})
+
+		DescribeTable("resolves the most specific credentials",
+			func(reference string, token string) {
+				creds, err := keychain.FromEnv()
+				Expect(err).ToNot(HaveOccurred())
+
+				repo, err := name.NewRepository(reference)
+				Expect(err).ToNot(HaveOccurred())
+				authentication, err := creds.Resolve(repo)
+				Expect(err).ToNot(HaveOccurred())
+				authorization, err := authentication.Authorization()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(authorization.RegistryToken).To(Equal(token))
+			},
+			Entry("wildcard host", "other.example.com/app", "wildcard"),
+			Entry("wildcard per label", "a.b.example.com/app", "two-wildcards"),
+			Entry("exact host over wildcard", "registry.example.com/app", "host"),
+			Entry("explicit port", "registry.example.com:5000/app", "port"),
+			Entry("host without port for other ports", "registry.example.com:6000/app", "host"),
+			Entry("path prefix", "registry.example.com/team-a/other", "team-a"),
+			Entry("longest path prefix", "registry.example.com/team-a/app", "team-a-app"),
+			Entry("path prefix per segment", "registry.example.com/team-ab/app", "host"),
+			Entry("no match", "example.com/app", ""),
 		)
 	})
 })
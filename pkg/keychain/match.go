@@ -0,0 +1,128 @@
+package keychain
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// pattern is a credential key in the form [scheme://]host[:port][/path], host labels may contain * wildcards
+type pattern struct {
+	labels []string
+	port   string
+	path   string
+}
+
+type credential struct {
+	key     string
+	pattern pattern
+	auth    auth
+	// oauth2 caches the token of client credentials for all requests
+	oauth2 *clientCredentials
+}
+
+func parsePattern(key string) pattern {
+	if _, after, ok := strings.Cut(key, "://"); ok {
+		key = after
+	}
+
+	hostPort, prefix, _ := strings.Cut(key, "/")
+	u := url.URL{Host: strings.ToLower(hostPort)}
+	return pattern{
+		labels: strings.Split(u.Hostname(), "."),
+		port:   u.Port(),
+		path:   strings.TrimSuffix("/"+prefix, "/"),
+	}
+}
+
+// matches reports whether the host labels match one by one, the port is the same unless the pattern has none,
+// and the path is below the path prefix of the pattern
+func (p pattern) matches(host, port, resourcePath string) bool {
+	labels := strings.Split(host, ".")
+	if len(labels) != len(p.labels) {
+		return false
+	}
+	for i, label := range p.labels {
+		if ok, err := path.Match(label, labels[i]); err != nil || !ok {
+			return false
+		}
+	}
+
+	if p.port != "" && p.port != port {
+		return false
+	}
+
+	return p.path == "" || resourcePath == p.path || strings.HasPrefix(resourcePath, p.path+"/")
+}
+
+// moreSpecific prefers patterns with fewer wildcard labels, then an explicit port, then the longest path prefix
+func (p pattern) moreSpecific(other pattern) bool {
+	if w, ow := p.wildcards(), other.wildcards(); w != ow {
+		return w < ow
+	}
+	if (p.port != "") != (other.port != "") {
+		return p.port != ""
+	}
+	return len(p.path) > len(other.path)
+}
+
+func (p pattern) wildcards() int {
+	count := 0
+	for _, label := range p.labels {
+		if strings.ContainsAny(label, "*?[") {
+			count++
+		}
+	}
+	return count
+}
+
+func newCredentials(credentials map[string]auth, transport http.RoundTripper) []credential {
+	result := make([]credential, 0, len(credentials))
+	for key, creds := range credentials {
+		c := credential{key: key, pattern: parsePattern(key), auth: creds}
+		if creds.isOAuth2() {
+			c.oauth2 = newClientCredentials(creds, transport)
+		}
+		result = append(result, c)
+	}
+	// equally specific patterns are resolved by key to be deterministic
+	slices.SortFunc(result, func(a, b credential) int {
+		return strings.Compare(a.key, b.key)
+	})
+	return result
+}
+
+// match returns the most specific credential for the registry and path of the resource
+func match(credentials []credential, resource authn.Resource) (*credential, bool) {
+	u := url.URL{Host: strings.ToLower(resource.RegistryStr())}
+	resourcePath := pathOf(resource)
+
+	var best *credential
+	for i, c := range credentials {
+		if !c.pattern.matches(u.Hostname(), u.Port(), resourcePath) {
+			continue
+		}
+		if best == nil || c.pattern.moreSpecific(best.pattern) {
+			best = &credentials[i]
+		}
+	}
+
+	return best, best != nil
+}
+
+func pathOf(resource authn.Resource) string {
+	if repo, ok := resource.(name.Repository); ok {
+		return "/" + repo.RepositoryStr()
+	}
+
+	resourcePath := strings.TrimPrefix(resource.String(), resource.RegistryStr())
+	if !strings.HasPrefix(resourcePath, "/") {
+		return ""
+	}
+	return resourcePath
+}
@@ -0,0 +1,95 @@
+package keychain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// tokenExpiryMargin renews tokens before they expire while a request is in flight
+const tokenExpiryMargin = 10 * time.Second
+
+// clientCredentials is an authenticator using the OAuth2 client credentials flow, the token is cached until it expires
+type clientCredentials struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	scopes       []string
+	client       *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newClientCredentials(a auth, transport http.RoundTripper) *clientCredentials {
+	return &clientCredentials{
+		clientID:     a.ClientID,
+		clientSecret: a.ClientSecret,
+		tokenURL:     a.TokenURL,
+		scopes:       a.Scopes,
+		client:       &http.Client{Transport: transport},
+	}
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func (c *clientCredentials) Authorization() (*authn.AuthConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && (c.expiry.IsZero() || time.Now().Before(c.expiry)) {
+		return &authn.AuthConfig{RegistryToken: c.token}, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.scopes) > 0 {
+		form.Set("scope", strings.Join(c.scopes, " "))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token from %s: %w", c.tokenURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to request token from %s: %s", c.tokenURL, res.Status)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxTokenSize)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token from %s: %w", c.tokenURL, err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in response from %s", c.tokenURL)
+	}
+
+	c.token = token.AccessToken
+	log.AddSecrets(c.token)
+	c.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		c.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+
+	return &authn.AuthConfig{RegistryToken: c.token}, nil
+}
@@ -0,0 +1,78 @@
+package log
+
+import (
+	"bytes"
+
+	apexLog "github.com/apex/log"
+	"github.com/buildpacks/lifecycle/buildpack"
+	"github.com/buildpacks/lifecycle/log"
+	"github.com/buildpacks/lifecycle/phase"
+)
+
+// BuildExecutor flushes the output written by each buildpack and records the buildpack being built for the JSON
+// log entries written meanwhile
+func (l *Logger) BuildExecutor(inner buildpack.BuildExecutor) buildpack.BuildExecutor {
+	return &buildExecutor{inner: inner, logger: l}
+}
+
+type buildExecutor struct {
+	inner  buildpack.BuildExecutor
+	logger *Logger
+}
+
+func (e *buildExecutor) Build(d buildpack.BpDescriptor, inputs buildpack.BuildInputs, logger log.Logger) (buildpack.BuildOutputs, error) {
+	e.setBuildpack(d.Buildpack.ID)
+	defer e.setBuildpack("")
+
+	return e.inner.Build(d, inputs, logger)
+}
+
+func (e *buildExecutor) setBuildpack(id string) {
+	e.logger.Flush()
+
+	if h, ok := e.logger.Handler.(*jsonHandler); ok {
+		h.setBuildpack(id)
+	}
+}
+
+// DetectExecutor logs the output of each buildpack as JSON log entries of the buildpack, buildpacks are detected
+// concurrently and their output would otherwise be logged afterwards without the buildpack
+func (l *Logger) DetectExecutor(inner buildpack.DetectExecutor) buildpack.DetectExecutor {
+	return &detectExecutor{inner: inner, logger: l}
+}
+
+type detectExecutor struct {
+	inner  buildpack.DetectExecutor
+	logger *Logger
+}
+
+func (e *detectExecutor) Detect(d buildpack.Descriptor, inputs buildpack.DetectInputs, logger log.Logger) buildpack.DetectOutputs {
+	outputs := e.inner.Detect(d, inputs, logger)
+
+	h, ok := e.logger.Handler.(*jsonHandler)
+	if !ok || len(outputs.Output) == 0 {
+		return outputs
+	}
+
+	var id string
+	switch descriptor := d.(type) {
+	case *buildpack.BpDescriptor:
+		id = descriptor.Buildpack.ID
+	case *buildpack.ExtDescriptor:
+		id = descriptor.Extension.ID
+	}
+
+	// like the lifecycle, the output is only logged at debug level unless detection errored
+	level := apexLog.DebugLevel
+	if outputs.Code != phase.CodeDetectPass && outputs.Code != phase.CodeDetectFail {
+		level = apexLog.InfoLevel
+	}
+	if level >= e.logger.LogLevel() {
+		for _, line := range bytes.Split(bytes.TrimSuffix(outputs.Output, []byte("\n")), []byte("\n")) {
+			_ = h.write(jsonEntry{Level: level.String(), Buildpack: id, Message: string(bytes.TrimSuffix(line, []byte("\r")))})
+		}
+	}
+	outputs.Output = nil
+
+	return outputs
+}
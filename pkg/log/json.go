@@ -0,0 +1,69 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	apexLog "github.com/apex/log"
+)
+
+type jsonEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Phase     string `json:"phase,omitempty"`
+	Buildpack string `json:"buildpack,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Message   string `json:"message"`
+}
+
+// jsonHandler writes log entries as JSON and keeps the phase and buildpack they belong to
+type jsonHandler struct {
+	mu        sync.Mutex
+	writer    io.Writer
+	phase     string
+	buildpack string
+}
+
+func (h *jsonHandler) HandleLog(entry *apexLog.Entry) error {
+	return h.write(jsonEntry{Level: entry.Level.String(), Message: strings.TrimSuffix(entry.Message, "\n")})
+}
+
+func (h *jsonHandler) write(entry jsonEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	entry.Phase = h.phase
+	if entry.Buildpack == "" {
+		entry.Buildpack = h.buildpack
+	}
+	entry.Message = Redact(entry.Message)
+
+	encoder := json.NewEncoder(h.writer)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(entry)
+}
+
+func (h *jsonHandler) setPhase(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.phase = strings.ToLower(name)
+	h.buildpack = ""
+}
+
+func (h *jsonHandler) setBuildpack(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buildpack = id
+}
+
+// lineWriter logs every line as an entry of source
+func (h *jsonHandler) lineWriter(source string) func(line []byte) error {
+	return func(line []byte) error {
+		return h.write(jsonEntry{Level: apexLog.InfoLevel.String(), Source: source, Message: string(bytes.TrimSuffix(line, []byte("\r")))})
+	}
+}
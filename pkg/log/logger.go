@@ -1,7 +1,10 @@
 package log
 
 import (
+	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	apexLog "github.com/apex/log"
 	"github.com/buildpacks/lifecycle/cmd"
@@ -9,23 +12,153 @@ import (
 	packLog "github.com/buildpacks/pack/pkg/logging"
 )
 
+const (
+	EnvLogFormat = "CNB_LOG_FORMAT"
+	FormatText   = "text"
+	FormatJSON   = "json"
+)
+
 var _ packLog.Logger = (*Logger)(nil)
 var _ log.LoggerHandlerWithLevel = (*Logger)(nil)
 
 type Logger struct {
 	*log.DefaultLogger
+	writer io.Writer
+
+	mu      sync.Mutex
+	outputs []*lineWriter
+	log     *lineWriter
+	onError func(message string)
 }
 
 func (l *Logger) IsVerbose() bool {
 	return l.LogLevel() == apexLog.DebugLevel
 }
 
+// Writer returns the writer for output of libraries like pack, with secrets masked
 func (l *Logger) Writer() io.Writer {
-	return cmd.Stdout
+	if h, ok := l.Handler.(*jsonHandler); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.log == nil {
+			l.log = l.newOutput(h.lineWriter(""))
+		}
+		return l.log
+	}
+
+	// not line buffered, progress output has to show up as it is written
+	return NewRedactingWriter(l.writer)
 }
 
 func NewLogger() *Logger {
+	return newLogger(cmd.DefaultLogger, cmd.Stdout)
+}
+
+// New returns a logger writing to writer instead of the shared lifecycle logger
+func New(writer io.Writer) *Logger {
+	return newLogger(log.NewDefaultLogger(writer), writer)
+}
+
+func newLogger(logger *log.DefaultLogger, writer io.Writer) *Logger {
+	switch logger.Handler.(type) {
+	case *redactingHandler, *jsonHandler:
+		// both mask secrets already
+	default:
+		logger.Handler = &redactingHandler{Handler: logger.Handler}
+	}
+
 	return &Logger{
-		DefaultLogger: cmd.DefaultLogger,
+		DefaultLogger: logger,
+		writer:        writer,
+	}
+}
+
+// SetFormat switches to one JSON object per line for the json format
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case "", FormatText:
+		return nil
+	case FormatJSON:
+		if _, ok := l.Handler.(*jsonHandler); !ok {
+			l.Handler = &jsonHandler{writer: l.writer}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown log format %q", format)
+}
+
+func (l *Logger) Phase(name string) {
+	l.Flush()
+
+	if h, ok := l.Handler.(*jsonHandler); ok {
+		h.setPhase(name)
+		l.Info(name)
+		return
+	}
+
+	l.DefaultLogger.Phase(name)
+}
+
+// Output wraps writer to mask secrets in every line written to it, and to log the lines as coming from source
+// when logging JSON
+func (l *Logger) Output(source string, writer io.Writer) io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if h, ok := l.Handler.(*jsonHandler); ok {
+		return l.newOutput(h.lineWriter(source))
 	}
+
+	return l.newOutput(func(line []byte) error {
+		_, err := io.WriteString(writer, Redact(string(line))+"\n")
+		return err
+	})
+}
+
+func (l *Logger) Error(msg string) {
+	l.DefaultLogger.Error(msg)
+	l.errorLogged(msg)
+}
+
+func (l *Logger) Errorf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	l.DefaultLogger.Error(msg)
+	l.errorLogged(msg)
+}
+
+// OnError calls fn with every message logged at error level
+func (l *Logger) OnError(fn func(message string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onError = fn
+}
+
+func (l *Logger) errorLogged(msg string) {
+	l.mu.Lock()
+	fn := l.onError
+	l.mu.Unlock()
+
+	if fn != nil {
+		fn(strings.TrimSuffix(msg, "\n"))
+	}
+}
+
+// Flush writes incomplete lines of all outputs
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	outputs := l.outputs
+	l.mu.Unlock()
+
+	for _, w := range outputs {
+		w.flush()
+	}
+}
+
+// newOutput requires l.mu to be held
+func (l *Logger) newOutput(writeLine func(line []byte) error) *lineWriter {
+	w := &lineWriter{writeLine: writeLine}
+	l.outputs = append(l.outputs, w)
+	return w
 }
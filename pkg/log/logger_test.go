@@ -0,0 +1,157 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/buildpacks/lifecycle/buildpack"
+	lifecycleLog "github.com/buildpacks/lifecycle/log"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeBuildExecutor struct{}
+
+func (fakeBuildExecutor) Build(d buildpack.BpDescriptor, inputs buildpack.BuildInputs, logger lifecycleLog.Logger) (buildpack.BuildOutputs, error) {
+	logger.Info("building")
+	_, err := fmt.Fprint(inputs.Out, "compiling\nno newline")
+	return buildpack.BuildOutputs{}, err
+}
+
+type fakeDetectExecutor struct {
+	outputs buildpack.DetectOutputs
+}
+
+func (e fakeDetectExecutor) Detect(d buildpack.Descriptor, inputs buildpack.DetectInputs, logger lifecycleLog.Logger) buildpack.DetectOutputs {
+	return e.outputs
+}
+
+var _ = Describe("Logger", func() {
+	var (
+		out    *bytes.Buffer
+		logger *log.Logger
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		logger = log.New(out)
+	})
+
+	entries := func() []map[string]string {
+		var result []map[string]string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			entry := map[string]string{}
+			Expect(json.Unmarshal([]byte(line), &entry)).To(Succeed())
+			Expect(entry).To(HaveKey("timestamp"))
+			delete(entry, "timestamp")
+			result = append(result, entry)
+		}
+		return result
+	}
+
+	It("logs text by default", func() {
+		Expect(logger.SetFormat("")).To(Succeed())
+		logger.Infof("hello %s", "world")
+
+		Expect(out.String()).To(Equal("hello world\n"))
+	})
+
+	It("passes error messages to the error hook", func() {
+		var messages []string
+		logger.OnError(func(message string) { messages = append(messages, message) })
+		logger.Infof("hello")
+		logger.Errorf("failed, error: %s\n", "reason")
+		logger.Error("failed")
+
+		Expect(messages).To(Equal([]string{"failed, error: reason", "failed"}))
+		Expect(out.String()).To(ContainSubstring("failed, error: reason\n"))
+	})
+
+	It("fails for unknown formats", func() {
+		Expect(logger.SetFormat("xml")).To(MatchError(`unknown log format "xml"`))
+	})
+
+	Context("json", func() {
+		BeforeEach(func() {
+			Expect(logger.SetFormat(log.FormatJSON)).To(Succeed())
+		})
+
+		It("logs one object per line with level and phase", func() {
+			logger.Info("starting")
+			logger.Phase("DETECTING")
+			logger.Errorf("failed, error: %s\n", `"quoted" <value>`)
+
+			Expect(entries()).To(Equal([]map[string]string{
+				{"level": "info", "message": "starting"},
+				{"level": "info", "phase": "detecting", "message": "DETECTING"},
+				{"level": "error", "phase": "detecting", "message": `failed, error: "quoted" <value>`},
+			}))
+		})
+
+		It("wraps output lines with their source and buildpack", func() {
+			logger.Phase("BUILDING")
+			executor := logger.BuildExecutor(fakeBuildExecutor{})
+			_, err := executor.Build(
+				buildpack.BpDescriptor{Buildpack: buildpack.BpInfo{BaseInfo: buildpack.BaseInfo{ID: "some/buildpack"}}},
+				buildpack.BuildInputs{Out: logger.Output("stdout", nil)},
+				logger,
+			)
+			Expect(err).NotTo(HaveOccurred())
+			logger.Info("done")
+
+			Expect(entries()).To(Equal([]map[string]string{
+				{"level": "info", "phase": "building", "message": "BUILDING"},
+				{"level": "info", "phase": "building", "buildpack": "some/buildpack", "message": "building"},
+				{"level": "info", "phase": "building", "buildpack": "some/buildpack", "source": "stdout", "message": "compiling"},
+				{"level": "info", "phase": "building", "buildpack": "some/buildpack", "source": "stdout", "message": "no newline"},
+				{"level": "info", "phase": "building", "message": "done"},
+			}))
+		})
+
+		It("logs the detect output of errored buildpacks with the buildpack", func() {
+			logger.Phase("DETECTING")
+			executor := logger.DetectExecutor(fakeDetectExecutor{outputs: buildpack.DetectOutputs{Code: 1, Output: []byte("checking\r\nbroken\n")}})
+			outputs := executor.Detect(
+				&buildpack.BpDescriptor{Buildpack: buildpack.BpInfo{BaseInfo: buildpack.BaseInfo{ID: "some/buildpack"}}},
+				buildpack.DetectInputs{},
+				logger,
+			)
+			Expect(outputs.Code).To(Equal(1))
+			Expect(outputs.Output).To(BeEmpty())
+
+			Expect(entries()).To(Equal([]map[string]string{
+				{"level": "info", "phase": "detecting", "message": "DETECTING"},
+				{"level": "info", "phase": "detecting", "buildpack": "some/buildpack", "message": "checking"},
+				{"level": "info", "phase": "detecting", "buildpack": "some/buildpack", "message": "broken"},
+			}))
+		})
+
+		It("logs the detect output of passing buildpacks at debug level", func() {
+			executor := logger.DetectExecutor(fakeDetectExecutor{outputs: buildpack.DetectOutputs{Code: 0, Output: []byte("detected\n")}})
+			descriptor := &buildpack.BpDescriptor{Buildpack: buildpack.BpInfo{BaseInfo: buildpack.BaseInfo{ID: "some/buildpack"}}}
+
+			Expect(logger.SetLevel("info")).To(Succeed())
+			executor.Detect(descriptor, buildpack.DetectInputs{}, logger)
+			Expect(out.String()).To(BeEmpty())
+
+			Expect(logger.SetLevel("debug")).To(Succeed())
+			executor.Detect(descriptor, buildpack.DetectInputs{}, logger)
+			Expect(entries()).To(Equal([]map[string]string{
+				{"level": "debug", "buildpack": "some/buildpack", "message": "detected"},
+			}))
+		})
+
+		It("logs lines written to the writer", func() {
+			_, err := fmt.Fprintln(logger.Writer(), "Downloading from https://example.com")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(entries()).To(Equal([]map[string]string{
+				{"level": "info", "message": "Downloading from https://example.com"},
+			}))
+		})
+	})
+})
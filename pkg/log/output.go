@@ -0,0 +1,43 @@
+package log
+
+import (
+	"bytes"
+	"sync"
+)
+
+// lineWriter passes every complete line written to it to writeLine
+type lineWriter struct {
+	mu        sync.Mutex
+	writeLine func(line []byte) error
+	buf       []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		line, rest, ok := bytes.Cut(w.buf, []byte("\n"))
+		if !ok {
+			break
+		}
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+		w.buf = rest
+	}
+
+	return len(p), nil
+}
+
+// flush writes an incomplete line before the phase or buildpack changes
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		_ = w.writeLine(w.buf)
+		w.buf = nil
+	}
+}
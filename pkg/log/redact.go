@@ -0,0 +1,148 @@
+package log
+
+import (
+	"cmp"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	apexLog "github.com/apex/log"
+)
+
+const (
+	Redacted = "[REDACTED]"
+	// minSecretLength keeps short values like ports or flags from being masked everywhere
+	minSecretLength = 4
+)
+
+// secretKey matches the keys of credentials holding secrets
+var secretKey = regexp.MustCompile(`(?i)password|secret|token|key|uri|url|credentials`)
+
+// secrets are masked in all log output
+var secrets = struct {
+	sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}{values: map[string]struct{}{}}
+
+// AddSecrets masks the values in everything logged from now on
+func AddSecrets(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	added := false
+	for _, value := range values {
+		if _, ok := secrets.values[value]; ok || len(value) < minSecretLength {
+			continue
+		}
+		secrets.values[value] = struct{}{}
+		added = true
+	}
+	if !added {
+		return
+	}
+
+	// longer secrets first, so a secret containing another one is masked completely
+	sorted := make([]string, 0, len(secrets.values))
+	for value := range secrets.values {
+		sorted = append(sorted, value)
+	}
+	slices.SortFunc(sorted, func(a, b string) int {
+		return cmp.Or(len(b)-len(a), strings.Compare(a, b))
+	})
+
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		oldnew = append(oldnew, value, Redacted)
+	}
+	secrets.replacer = strings.NewReplacer(oldnew...)
+}
+
+// IsSecretKey reports whether a credential key, like "password" or "db_uri", names a secret value
+func IsSecretKey(key string) bool {
+	return secretKey.MatchString(key)
+}
+
+// AddSecretValues masks the strings below secret-like keys in a JSON value like service credentials, a string that
+// is not below a key is masked as it is the secret itself
+func AddSecretValues(value any) {
+	if v, ok := value.(string); ok {
+		AddSecrets(v)
+		return
+	}
+
+	addSecretKeyValues(value)
+}
+
+func addSecretKeyValues(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if IsSecretKey(key) {
+				addAllValues(item)
+			} else {
+				addSecretKeyValues(item)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			addSecretKeyValues(item)
+		}
+	}
+}
+
+func addAllValues(value any) {
+	switch v := value.(type) {
+	case string:
+		AddSecrets(v)
+	case map[string]any:
+		for _, item := range v {
+			addAllValues(item)
+		}
+	case []any:
+		for _, item := range v {
+			addAllValues(item)
+		}
+	}
+}
+
+// Redact masks all secrets in s
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	if secrets.replacer == nil {
+		return s
+	}
+	return secrets.replacer.Replace(s)
+}
+
+// redactingHandler masks secrets in the messages of another handler
+type redactingHandler struct {
+	apexLog.Handler
+}
+
+func (h *redactingHandler) HandleLog(entry *apexLog.Entry) error {
+	redacted := *entry
+	redacted.Message = Redact(entry.Message)
+	return h.Handler.HandleLog(&redacted)
+}
+
+// NewRedactingWriter masks secrets in every write to writer
+func NewRedactingWriter(writer io.Writer) io.Writer {
+	return &redactingWriter{writer: writer}
+}
+
+// redactingWriter masks secrets in every write, for output that is not line based like progress bars
+type redactingWriter struct {
+	writer io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.writer, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
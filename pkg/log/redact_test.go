@@ -0,0 +1,105 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/buildpacks/lifecycle/buildpack"
+	lifecycleLog "github.com/buildpacks/lifecycle/log"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type secretBuildExecutor struct{}
+
+func (secretBuildExecutor) Build(d buildpack.BpDescriptor, inputs buildpack.BuildInputs, logger lifecycleLog.Logger) (buildpack.BuildOutputs, error) {
+	_, err := fmt.Fprint(inputs.Out, "compiling\nusing buildpack-secret")
+	return buildpack.BuildOutputs{}, err
+}
+
+var _ = Describe("Redact", func() {
+	var (
+		out    *bytes.Buffer
+		logger *log.Logger
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		logger = log.New(out)
+	})
+
+	It("masks added secrets, longest first", func() {
+		log.AddSecrets("s3cr3t-value", "s3cr3t-value-long", "abc", "")
+
+		Expect(log.Redact("a s3cr3t-value-long and s3cr3t-value, abc")).To(Equal("a [REDACTED] and [REDACTED], abc"))
+	})
+
+	It("masks the values of secret-like credentials", func() {
+		log.AddSecretValues(map[string]any{
+			"uri":      "postgres://user:cred-password@db",
+			"hosts":    []any{"cred-host-1"},
+			"port":     "15432",
+			"ssl":      "false",
+			"username": "cred-user",
+			"tls":      map[string]any{"private_key": []any{"cred-key"}},
+		})
+
+		Expect(log.Redact("postgres://user:cred-password@db at cred-host-1:15432 as cred-user, ssl false, key cred-key")).
+			To(Equal("[REDACTED] at cred-host-1:15432 as cred-user, ssl false, key [REDACTED]"))
+	})
+
+	It("masks a credential that is a string", func() {
+		log.AddSecretValues("cred-string-value")
+
+		Expect(log.Redact("using cred-string-value")).To(Equal("using [REDACTED]"))
+	})
+
+	It("masks log messages", func() {
+		log.AddSecrets("text-secret")
+		logger.Infof("using %s", "text-secret")
+
+		Expect(out.String()).To(Equal("using [REDACTED]\n"))
+	})
+
+	It("masks JSON log messages", func() {
+		log.AddSecrets("json-secret")
+		Expect(logger.SetFormat(log.FormatJSON)).To(Succeed())
+		logger.Infof("using %s", "json-secret")
+
+		Expect(out.String()).To(ContainSubstring(`"message":"using [REDACTED]"`))
+		Expect(out.String()).NotTo(ContainSubstring("json-secret"))
+	})
+
+	It("masks secrets split across writes to an output", func() {
+		log.AddSecrets("output-secret")
+		output := logger.Output("stdout", out)
+
+		_, err := fmt.Fprint(output, "token output-")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = fmt.Fprint(output, "secret\nlast output-secret")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(Equal("token [REDACTED]\n"))
+
+		logger.Flush()
+		Expect(out.String()).To(Equal("token [REDACTED]\nlast [REDACTED]\n"))
+	})
+
+	It("flushes outputs after each buildpack", func() {
+		log.AddSecrets("buildpack-secret")
+		executor := logger.BuildExecutor(secretBuildExecutor{})
+		_, err := executor.Build(buildpack.BpDescriptor{}, buildpack.BuildInputs{Out: logger.Output("stdout", out)}, logger)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(out.String()).To(Equal("compiling\nusing [REDACTED]\n"))
+	})
+
+	It("masks the writer output", func() {
+		log.AddSecrets("writer-secret")
+		_, err := fmt.Fprint(logger.Writer(), "\rprogress writer-secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(out.String()).To(Equal("\rprogress [REDACTED]"))
+	})
+})
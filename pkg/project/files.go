@@ -0,0 +1,155 @@
+package project
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// pattern is a gitignore style file pattern, supporting "*", "?", "[...]" and "**" wildcards, a leading
+// "/" to anchor the pattern to the app root, a trailing "/" to match only directories and a leading "!"
+// to negate a previous match
+type pattern struct {
+	segments []string
+	dirOnly  bool
+	negate   bool
+}
+
+func parsePatterns(lines []string) []pattern {
+	patterns := []pattern{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := pattern{}
+		if p.negate = strings.HasPrefix(line, "!"); p.negate {
+			line = line[1:]
+		}
+		if p.dirOnly = strings.HasSuffix(line, "/"); p.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+
+		// patterns without a slash match at any depth
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+
+		p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		patterns = append(patterns, p)
+	}
+
+	return patterns
+}
+
+// matchPath reports whether the slash separated path or one of its parent directories matches the patterns,
+// the last matching pattern wins
+func matchPath(patterns []pattern, rel string, isDir bool) bool {
+	segments := strings.Split(rel, "/")
+	matched := false
+
+	for _, p := range patterns {
+		for i := 1; i <= len(segments); i++ {
+			if p.dirOnly && i == len(segments) && !isDir {
+				continue
+			}
+
+			if matchSegments(p.segments, segments[:i]) {
+				matched = !p.negate
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	ok, err := path.Match(pattern[0], segments[0])
+	return err == nil && ok && matchSegments(pattern[1:], segments[1:])
+}
+
+// FilterFiles removes the files in appDir that are excluded, or not included, by the project descriptor
+func (d *Descriptor) FilterFiles(appDir string) error {
+	include := parsePatterns(d.IO.Buildpacks.Include)
+	exclude := parsePatterns(d.IO.Buildpacks.Exclude)
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	dirs := []string{}
+	err := filepath.WalkDir(appDir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(appDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if len(exclude) > 0 {
+			if !matchPath(exclude, rel, entry.IsDir()) {
+				return nil
+			}
+		} else if matchPath(include, rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if entry.IsDir() {
+			// a directory can still contain included files
+			dirs = append(dirs, p)
+			return nil
+		}
+
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// remove directories left without any included files, deepest first
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package project_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/project"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FilterFiles", func() {
+	var appDir string
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = os.MkdirTemp("", "app")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, file := range []string{"main.go", "debug.log", "src/app.go", "src/tmp/cache.log", "docs/readme.md", "tmp/data"} {
+			Expect(os.MkdirAll(filepath.Join(appDir, filepath.Dir(file)), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(appDir, file), nil, 0o644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	filter := func(include, exclude []string) {
+		descriptor := &project.Descriptor{IO: project.IO{Buildpacks: project.Buildpacks{Include: include, Exclude: exclude}}}
+		Expect(descriptor.FilterFiles(appDir)).To(Succeed())
+	}
+
+	It("removes excluded files", func() {
+		filter(nil, []string{"*.log", "/tmp/", "docs/**"})
+
+		Expect(filepath.Join(appDir, "main.go")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "app.go")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "tmp")).To(BeADirectory())
+		Expect(filepath.Join(appDir, "debug.log")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "tmp", "cache.log")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "tmp")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "docs", "readme.md")).NotTo(BeAnExistingFile())
+	})
+
+	It("supports negated patterns", func() {
+		filter(nil, []string{"*.log", "!debug.log"})
+
+		Expect(filepath.Join(appDir, "debug.log")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "tmp", "cache.log")).NotTo(BeAnExistingFile())
+	})
+
+	It("keeps only included files", func() {
+		filter([]string{"*.go", "docs/"}, nil)
+
+		Expect(filepath.Join(appDir, "main.go")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "app.go")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "docs", "readme.md")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "debug.log")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "src", "tmp")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "tmp")).NotTo(BeAnExistingFile())
+	})
+
+	It("does nothing without patterns", func() {
+		filter(nil, nil)
+
+		Expect(filepath.Join(appDir, "debug.log")).To(BeAnExistingFile())
+		Expect(filepath.Join(appDir, "tmp", "data")).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,219 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	FileName      = "project.toml"
+	SchemaVersion = "0.2"
+
+	defaultInlineShell   = "/bin/sh"
+	defaultInlineVersion = "0.0.0"
+)
+
+// buildpackID matches the IDs allowed by the buildpack spec, letters, numbers, ".", "-" and "/" separated parts
+var buildpackID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*(/[A-Za-z0-9][A-Za-z0-9.-]*)*$`)
+
+type Descriptor struct {
+	Schema Schema `toml:"_"`
+	IO     IO     `toml:"io"`
+}
+
+type Schema struct {
+	SchemaVersion string `toml:"schema-version"`
+	ID            string `toml:"id"`
+	Name          string `toml:"name"`
+	Version       string `toml:"version"`
+}
+
+type IO struct {
+	Buildpacks Buildpacks `toml:"buildpacks"`
+}
+
+type Buildpacks struct {
+	Include []string    `toml:"include"`
+	Exclude []string    `toml:"exclude"`
+	Group   []Buildpack `toml:"group"`
+	Build   Build       `toml:"build"`
+}
+
+type Buildpack struct {
+	ID      string `toml:"id"`
+	Version string `toml:"version"`
+	URI     string `toml:"uri"`
+	Script  Script `toml:"script"`
+}
+
+type Script struct {
+	API    string `toml:"api"`
+	Inline string `toml:"inline"`
+	Shell  string `toml:"shell"`
+}
+
+type Build struct {
+	Env []EnvVar `toml:"env"`
+}
+
+type EnvVar struct {
+	Name  string `toml:"name"`
+	Value string `toml:"value"`
+}
+
+// Read parses the project descriptor in appDir, it returns nil if the app has none or its schema version
+// is not supported
+func Read(appDir string, logger *log.Logger) (*Descriptor, error) {
+	descriptor := &Descriptor{}
+	if _, err := toml.DecodeFile(filepath.Join(appDir, FileName), descriptor); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to parse %s: %w", FileName, err)
+	}
+
+	if descriptor.Schema.SchemaVersion != SchemaVersion {
+		logger.Warnf("Ignoring %s, schema version %q is not supported, only %q is", FileName, descriptor.Schema.SchemaVersion, SchemaVersion)
+		return nil, nil
+	}
+
+	if err := descriptor.validate(logger); err != nil {
+		return nil, err
+	}
+
+	return descriptor, nil
+}
+
+// validate fails for invalid descriptors and drops group buildpacks that can only be resolved by a builder
+func (d *Descriptor) validate(logger *log.Logger) error {
+	if len(d.IO.Buildpacks.Include) > 0 && len(d.IO.Buildpacks.Exclude) > 0 {
+		return fmt.Errorf("%s must not contain both include and exclude", FileName)
+	}
+
+	group := []Buildpack{}
+	for _, bp := range d.IO.Buildpacks.Group {
+		if bp.Script.Inline != "" {
+			if bp.ID == "" || bp.Script.API == "" {
+				return fmt.Errorf("inline buildpack in %s requires an id and script api", FileName)
+			}
+		} else if bp.URI == "" {
+			logger.Warnf("Ignoring buildpack %q from %s, buildpacks in the group need a uri or an inline script", bp.ID, FileName)
+			continue
+		}
+
+		group = append(group, bp)
+	}
+	d.IO.Buildpacks.Group = group
+
+	for _, env := range d.IO.Buildpacks.Build.Env {
+		if env.Name == "" || strings.ContainsAny(env.Name, "/=") {
+			return fmt.Errorf("invalid build env name %q in %s", env.Name, FileName)
+		}
+	}
+
+	return nil
+}
+
+// Buildpacks returns the buildpacks to use and whether auto-detection still applies. Buildpacks passed
+// explicitly take precedence over the project group, with auto-detection the project group replaces the
+// detected buildpacks and is run as a single group. Inline buildpacks are written to inlineDir.
+func (d *Descriptor) Buildpacks(buildpacks []string, autoDetect bool, inlineDir string, logger *log.Logger) ([]string, bool, error) {
+	if len(d.IO.Buildpacks.Group) == 0 {
+		return buildpacks, autoDetect, nil
+	}
+
+	if !autoDetect {
+		logger.Infof("Ignoring buildpacks group from %s, buildpacks were requested explicitly", FileName)
+		return buildpacks, autoDetect, nil
+	}
+
+	result := []string{}
+	for _, bp := range d.IO.Buildpacks.Group {
+		if bp.Script.Inline == "" {
+			result = append(result, bp.URI)
+			continue
+		}
+
+		dir, err := writeInlineBuildpack(bp, inlineDir)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create inline buildpack %q: %w", bp.ID, err)
+		}
+		result = append(result, "file://"+dir)
+	}
+
+	logger.Infof("Using buildpacks group from %s", FileName)
+	return result, false, nil
+}
+
+func writeInlineBuildpack(bp Buildpack, inlineDir string) (string, error) {
+	version := bp.Version
+	if version == "" {
+		version = defaultInlineVersion
+	}
+	shell := bp.Script.Shell
+	if shell == "" {
+		shell = defaultInlineShell
+	}
+
+	name := strings.ReplaceAll(bp.ID, "/", "_")
+	if !buildpackID.MatchString(bp.ID) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid buildpack ID %q", bp.ID)
+	}
+
+	dir := filepath.Join(inlineDir, name)
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0o755); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "buildpack.toml"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := toml.NewEncoder(f).Encode(map[string]any{
+		"api": bp.Script.API,
+		"buildpack": map[string]string{
+			"id":      bp.ID,
+			"version": version,
+		},
+	}); err != nil {
+		return "", err
+	}
+
+	scripts := map[string]string{
+		"detect": fmt.Sprintf("#!%s\n\nexit 0\n", shell),
+		"build":  fmt.Sprintf("#!%s\n\n%s\n", shell, bp.Script.Inline),
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, "bin", name), []byte(content), 0o755); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// WriteBuildEnv writes the build env of the project to the platform env dir
+func (d *Descriptor) WriteBuildEnv(platformDir string) error {
+	envDir := filepath.Join(platformDir, "env")
+	if err := os.MkdirAll(envDir, 0o755); err != nil {
+		return err
+	}
+
+	for _, env := range d.IO.Buildpacks.Build.Env {
+		if err := os.WriteFile(filepath.Join(envDir, env.Name), []byte(env.Value), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
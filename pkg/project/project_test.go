@@ -0,0 +1,165 @@
+package project_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/project"
+	"github.com/BurntSushi/toml"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project", func() {
+	var appDir string
+	var logger = log.NewLogger()
+
+	writeDescriptor := func(content string) {
+		Expect(os.WriteFile(filepath.Join(appDir, project.FileName), []byte(content), 0o644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		appDir, err = os.MkdirTemp("", "app")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	Describe("Read", func() {
+		It("returns nil without a project descriptor", func() {
+			descriptor, err := project.Read(appDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(descriptor).To(BeNil())
+		})
+
+		It("parses the project descriptor", func() {
+			writeDescriptor(`
+[_]
+schema-version = "0.2"
+id = "my-app"
+
+[io.buildpacks]
+exclude = ["*.log"]
+
+[[io.buildpacks.group]]
+uri = "docker://example.com/bp:1"
+
+[[io.buildpacks.build.env]]
+name = "FOO"
+value = "bar"
+`)
+			descriptor, err := project.Read(appDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(descriptor.Schema.ID).To(Equal("my-app"))
+			Expect(descriptor.IO.Buildpacks.Exclude).To(Equal([]string{"*.log"}))
+			Expect(descriptor.IO.Buildpacks.Group).To(HaveLen(1))
+			Expect(descriptor.IO.Buildpacks.Build.Env).To(Equal([]project.EnvVar{{Name: "FOO", Value: "bar"}}))
+		})
+
+		It("ignores a descriptor with an unsupported schema version", func() {
+			writeDescriptor("[_]\nschema-version = \"0.1\"\n[[io.buildpacks.group]]\nuri = \"some-bp\"\n")
+			descriptor, err := project.Read(appDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(descriptor).To(BeNil())
+		})
+
+		It("fails when include and exclude are both set", func() {
+			writeDescriptor("[_]\nschema-version = \"0.2\"\n[io.buildpacks]\ninclude = [\"a\"]\nexclude = [\"b\"]\n")
+			_, err := project.Read(appDir, logger)
+			Expect(err).To(MatchError(ContainSubstring("must not contain both include and exclude")))
+		})
+
+		It("skips buildpacks without uri or script", func() {
+			writeDescriptor("[_]\nschema-version = \"0.2\"\n[[io.buildpacks.group]]\nid = \"some/bp\"\n[[io.buildpacks.group]]\nuri = \"other-bp\"\n")
+			descriptor, err := project.Read(appDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(descriptor.IO.Buildpacks.Group).To(Equal([]project.Buildpack{{URI: "other-bp"}}))
+		})
+
+		It("fails for invalid toml", func() {
+			writeDescriptor("[_")
+			_, err := project.Read(appDir, logger)
+			Expect(err).To(MatchError(ContainSubstring("failed to parse project.toml")))
+		})
+	})
+
+	Describe("Buildpacks", func() {
+		var inlineDir string
+		var descriptor *project.Descriptor
+
+		BeforeEach(func() {
+			var err error
+			inlineDir, err = os.MkdirTemp("", "inline")
+			Expect(err).NotTo(HaveOccurred())
+
+			descriptor = &project.Descriptor{IO: project.IO{Buildpacks: project.Buildpacks{Group: []project.Buildpack{
+				{URI: "docker://example.com/bp:1"},
+				{ID: "example/inline", Script: project.Script{API: "0.10", Inline: "echo hello"}},
+			}}}}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(inlineDir)).To(Succeed())
+		})
+
+		It("keeps explicitly requested buildpacks", func() {
+			bps, autoDetect, err := descriptor.Buildpacks([]string{"some-bp"}, false, inlineDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bps).To(Equal([]string{"some-bp"}))
+			Expect(autoDetect).To(BeFalse())
+		})
+
+		It("uses the project group with auto-detection", func() {
+			bps, autoDetect, err := descriptor.Buildpacks([]string{"some-bp"}, true, inlineDir, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(autoDetect).To(BeFalse())
+
+			bpDir := filepath.Join(inlineDir, "example_inline")
+			Expect(bps).To(Equal([]string{"docker://example.com/bp:1", "file://" + bpDir}))
+
+			bpToml := struct {
+				API       string `toml:"api"`
+				Buildpack struct {
+					ID      string `toml:"id"`
+					Version string `toml:"version"`
+				} `toml:"buildpack"`
+			}{}
+			_, err = toml.DecodeFile(filepath.Join(bpDir, "buildpack.toml"), &bpToml)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bpToml.API).To(Equal("0.10"))
+			Expect(bpToml.Buildpack.ID).To(Equal("example/inline"))
+			Expect(bpToml.Buildpack.Version).To(Equal("0.0.0"))
+
+			build, err := os.ReadFile(filepath.Join(bpDir, "bin", "build"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(build)).To(Equal("#!/bin/sh\n\necho hello\n"))
+			Expect(filepath.Join(bpDir, "bin", "detect")).To(BeAnExistingFile())
+		})
+
+		It("rejects inline buildpack IDs outside of the inline dir", func() {
+			descriptor.IO.Buildpacks.Group[1].ID = ".."
+
+			_, _, err := descriptor.Buildpacks(nil, true, inlineDir, logger)
+			Expect(err).To(MatchError(`failed to create inline buildpack "..": invalid buildpack ID ".."`))
+			Expect(filepath.Join(filepath.Dir(inlineDir), "buildpack.toml")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Describe("WriteBuildEnv", func() {
+		It("writes the build env to the platform dir", func() {
+			descriptor := &project.Descriptor{IO: project.IO{Buildpacks: project.Buildpacks{Build: project.Build{
+				Env: []project.EnvVar{{Name: "FOO", Value: "bar"}},
+			}}}}
+
+			Expect(descriptor.WriteBuildEnv(appDir)).To(Succeed())
+			Expect(filepath.Join(appDir, "env", "FOO")).To(BeAnExistingFile())
+			content, err := os.ReadFile(filepath.Join(appDir, "env", "FOO"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("bar"))
+		})
+	})
+})
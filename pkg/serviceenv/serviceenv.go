@@ -0,0 +1,222 @@
+package serviceenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/lifecycle/platform"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
+)
+
+const FileName = "service-env.toml"
+
+// OperatorDir is the dir of the operator supplied mapping file, the default platform dir of the lifecycle which the
+// app cannot write to
+var OperatorDir = platform.DefaultPlatformDir
+
+// Mapping renders the env var Name from the first service matching Service, Label and Tag
+type Mapping struct {
+	Name     string `toml:"name"`
+	Service  string `toml:"service"`
+	Label    string `toml:"label"`
+	Tag      string `toml:"tag"`
+	Template string `toml:"template"`
+	Required bool   `toml:"required"`
+}
+
+type File struct {
+	Env []Mapping `toml:"env"`
+}
+
+// Paths returns the operator supplied mapping file in platformDir followed by the one in appDir, mappings of the
+// operator take precedence over the ones of the app
+func Paths(platformDir, appDir string) []string {
+	return []string{filepath.Join(platformDir, FileName), filepath.Join(appDir, FileName)}
+}
+
+// Read returns the mappings of all existing files, in order
+func Read(paths ...string) ([]Mapping, error) {
+	mappings := []Mapping{}
+	for _, path := range paths {
+		file := File{}
+		if _, err := toml.DecodeFile(path, &file); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+		}
+
+		for _, mapping := range file.Env {
+			if mapping.Name == "" || mapping.Template == "" {
+				return nil, fmt.Errorf("entry in %q requires a name and a template", path)
+			}
+		}
+
+		mappings = append(mappings, file.Env...)
+	}
+
+	return mappings, nil
+}
+
+// Render returns the env vars of the mappings, an env var is rendered by the first of its mappings with a matching
+// service, so earlier mappings take precedence. Mappings without a matching service are skipped unless they are
+// required. Values rendered from secret-like credentials, like passwords, tokens or URIs, are masked in the logs.
+func Render(mappings []Mapping, services vcap.Services) (map[string]string, error) {
+	env := map[string]string{}
+	for _, mapping := range mappings {
+		if _, ok := env[mapping.Name]; ok {
+			continue
+		}
+
+		svc, ok := mapping.find(services)
+		if !ok {
+			if mapping.Required {
+				return nil, fmt.Errorf("no service found for required env var %q", mapping.Name)
+			}
+			continue
+		}
+
+		tmpl, err := template.New(mapping.Name).Option("missingkey=error").Parse(mapping.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template of env var %q: %w", mapping.Name, err)
+		}
+
+		value := &bytes.Buffer{}
+		if err := tmpl.Execute(value, svc); err != nil {
+			return nil, fmt.Errorf("failed to render env var %q from service %q: %w", mapping.Name, svc.Name, err)
+		}
+
+		env[mapping.Name] = value.String()
+		if referencesSecret(tmpl.Root) {
+			log.AddSecrets(value.String())
+		}
+	}
+
+	return env, nil
+}
+
+// referencesSecret reports whether a template node refers to a secret-like credential, as field or as index key
+func referencesSecret(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		return n != nil && slices.ContainsFunc(n.Nodes, referencesSecret)
+	case *parse.ActionNode:
+		return referencesSecret(n.Pipe)
+	case *parse.PipeNode:
+		return n != nil && slices.ContainsFunc(n.Cmds, func(cmd *parse.CommandNode) bool { return referencesSecret(cmd) })
+	case *parse.CommandNode:
+		return slices.ContainsFunc(n.Args, referencesSecret)
+	case *parse.ChainNode:
+		return referencesSecret(n.Node) || slices.ContainsFunc(n.Field, isSecretIdent)
+	case *parse.FieldNode:
+		return slices.ContainsFunc(n.Ident, isSecretIdent)
+	case *parse.VariableNode:
+		return slices.ContainsFunc(n.Ident, isSecretIdent)
+	case *parse.StringNode:
+		return log.IsSecretKey(n.Text)
+	case *parse.IfNode:
+		return referencesSecret(n.Pipe) || referencesSecret(n.List) || referencesSecret(n.ElseList)
+	case *parse.RangeNode:
+		return referencesSecret(n.Pipe) || referencesSecret(n.List) || referencesSecret(n.ElseList)
+	case *parse.WithNode:
+		return referencesSecret(n.Pipe) || referencesSecret(n.List) || referencesSecret(n.ElseList)
+	case *parse.TemplateNode:
+		return referencesSecret(n.Pipe)
+	}
+
+	return false
+}
+
+// isSecretIdent reports whether a field refers to a secret-like credential, the Credentials field of the service is
+// the credentials object itself
+func isSecretIdent(ident string) bool {
+	return ident != "Credentials" && log.IsSecretKey(ident)
+}
+
+func (m Mapping) find(services vcap.Services) (vcap.Service, bool) {
+	for _, svc := range services.All() {
+		if (m.Service == "" || svc.Name == m.Service) &&
+			(m.Label == "" || svc.Label == m.Label) &&
+			(m.Tag == "" || svc.HasTag(m.Tag)) {
+			return svc, true
+		}
+	}
+
+	return vcap.Service{}, false
+}
+
+// Load renders the mappings from paths over the services from VCAP_SERVICES
+func Load(paths ...string) (map[string]string, error) {
+	mappings, err := Read(paths...)
+	if err != nil || len(mappings) == 0 {
+		return nil, err
+	}
+
+	services, err := vcap.ServicesFromEnv(vcap.Strict)
+	if err != nil {
+		return nil, err
+	}
+
+	return Render(mappings, services)
+}
+
+// WriteEnvFiles writes env to the platform env dir, without overriding env vars that are already passed to buildpacks
+func WriteEnvFiles(platformDir string, env map[string]string) ([]string, error) {
+	envDir := filepath.Join(platformDir, "env")
+	if err := os.MkdirAll(envDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	written := []string{}
+	for _, name := range sortedNames(env) {
+		path := filepath.Join(envDir, name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		if err := os.WriteFile(path, []byte(env[name]), 0o644); err != nil {
+			return nil, err
+		}
+		written = append(written, name)
+	}
+
+	return written, nil
+}
+
+// Setenv sets env in the current process, without overriding env vars that are already set
+func Setenv(env map[string]string) ([]string, error) {
+	set := []string{}
+	for _, name := range sortedNames(env) {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+
+		if err := os.Setenv(name, env[name]); err != nil {
+			return nil, err
+		}
+		set = append(set, name)
+	}
+
+	return set, nil
+}
+
+func sortedNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,13 @@
+package serviceenv_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestServiceenv(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Serviceenv Suite")
+}
@@ -0,0 +1,176 @@
+package serviceenv_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/serviceenv"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("serviceenv", func() {
+	var services vcap.Services
+
+	BeforeEach(func() {
+		var err error
+		services, err = vcap.ParseServices(`{
+			"p.redis": [{"name": "cache", "tags": ["redis"], "credentials": {"host": "redis.example.com", "port": 6379, "password": "p@ss"}}],
+			"cloudamqp": [{"name": "queue", "tags": ["amqp"], "credentials": {"uri": "amqp://rabbit"}}]
+		}`, vcap.Strict)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Render", func() {
+		It("renders env vars from matching services", func() {
+			env, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "REDIS_URL", Tag: "redis", Template: `redis://:{{ urlquery .Credentials.password }}@{{ .Credentials.host }}:{{ .Credentials.port }}`},
+				{Name: "AMQP_URL", Label: "cloudamqp", Template: `{{ .Credentials.uri }}`},
+				{Name: "QUEUE_NAME", Service: "queue", Template: `{{ .Name }}`},
+			}, services)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal(map[string]string{
+				"REDIS_URL":  "redis://:p%40ss@redis.example.com:6379",
+				"AMQP_URL":   "amqp://rabbit",
+				"QUEUE_NAME": "queue",
+			}))
+		})
+
+		It("masks the rendered values in the logs", func() {
+			_, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "CACHE_URL", Tag: "redis", Template: `cache://:{{ urlquery .Credentials.password }}@{{ .Credentials.host }}`},
+			}, services)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(log.Redact("connecting to cache://:p%40ss@redis.example.com")).To(Equal("connecting to " + log.Redacted))
+		})
+
+		It("does not mask values rendered from other credentials", func() {
+			_, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "REDIS_HOST", Tag: "redis", Template: `{{ .Credentials.host }}`},
+				{Name: "QUEUE_URL", Tag: "amqp", Template: `{{ index .Credentials "uri" }}`},
+			}, services)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(log.Redact("redis.example.com")).To(Equal("redis.example.com"))
+			Expect(log.Redact("amqp://rabbit")).To(Equal(log.Redacted))
+		})
+
+		It("skips mappings without a matching service", func() {
+			env, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "SMTP_HOST", Tag: "smtp", Template: `{{ .Credentials.host }}`},
+				{Name: "REDIS_HOST", Tag: "redis", Label: "other", Template: `{{ .Credentials.host }}`},
+			}, services)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(BeEmpty())
+		})
+
+		It("fails for required mappings without a matching service", func() {
+			_, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "SMTP_HOST", Tag: "smtp", Template: `{{ .Credentials.host }}`, Required: true},
+			}, services)
+			Expect(err).To(MatchError(`no service found for required env var "SMTP_HOST"`))
+		})
+
+		It("renders env vars from the first mapping with a matching service", func() {
+			env, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "CACHE_HOST", Tag: "valkey", Template: `valkey`},
+				{Name: "CACHE_HOST", Tag: "redis", Template: `{{ .Credentials.host }}`},
+				{Name: "CACHE_HOST", Tag: "amqp", Template: `rabbit`, Required: true},
+			}, services)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal(map[string]string{"CACHE_HOST": "redis.example.com"}))
+		})
+
+		It("fails for missing credentials", func() {
+			_, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "REDIS_USER", Tag: "redis", Template: `{{ .Credentials.username }}`},
+			}, services)
+			Expect(err).To(MatchError(ContainSubstring(`failed to render env var "REDIS_USER" from service "cache"`)))
+		})
+
+		It("fails for invalid templates", func() {
+			_, err := serviceenv.Render([]serviceenv.Mapping{
+				{Name: "REDIS_URL", Tag: "redis", Template: `{{ .Credentials.host`},
+			}, services)
+			Expect(err).To(MatchError(ContainSubstring(`failed to parse template of env var "REDIS_URL"`)))
+		})
+	})
+
+	Describe("Read", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		It("reads the mappings of all existing files in order", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "operator.toml"), []byte("[[env]]\nname = \"A\"\ntemplate = \"a\"\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, serviceenv.FileName), []byte("[[env]]\nname = \"B\"\ntag = \"redis\"\ntemplate = \"b\"\n"), 0o644)).To(Succeed())
+
+			mappings, err := serviceenv.Read(filepath.Join(dir, "operator.toml"), filepath.Join(dir, "missing.toml"), filepath.Join(dir, serviceenv.FileName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mappings).To(Equal([]serviceenv.Mapping{
+				{Name: "A", Template: "a"},
+				{Name: "B", Tag: "redis", Template: "b"},
+			}))
+		})
+
+		It("fails for entries without a template", func() {
+			Expect(os.WriteFile(filepath.Join(dir, serviceenv.FileName), []byte("[[env]]\nname = \"A\"\n"), 0o644)).To(Succeed())
+
+			_, err := serviceenv.Read(filepath.Join(dir, serviceenv.FileName))
+			Expect(err).To(MatchError(ContainSubstring("requires a name and a template")))
+		})
+
+		It("returns the operator file before the app file", func() {
+			Expect(serviceenv.Paths("/platform", "/app")).To(Equal([]string{"/platform/service-env.toml", "/app/service-env.toml"}))
+		})
+	})
+
+	Describe("Load", func() {
+		It("prefers the mappings of the operator over the ones of the app", func() {
+			appDir := GinkgoT().TempDir()
+			platformDir := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(platformDir, serviceenv.FileName), []byte("[[env]]\nname = \"QUEUE_URL\"\ntag = \"amqp\"\ntemplate = \"{{ .Credentials.uri }}\"\n"), 0o644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(appDir, serviceenv.FileName), []byte("[[env]]\nname = \"QUEUE_URL\"\ntag = \"amqp\"\ntemplate = \"amqp://app\"\n\n[[env]]\nname = \"QUEUE_NAME\"\ntag = \"amqp\"\ntemplate = \"{{ .Name }}\"\n"), 0o644)).To(Succeed())
+			GinkgoT().Setenv(vcap.ServicesEnv, `{"cloudamqp": [{"name": "queue", "tags": ["amqp"], "credentials": {"uri": "amqp://rabbit"}}]}`)
+
+			env, err := serviceenv.Load(serviceenv.Paths(platformDir, appDir)...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(env).To(Equal(map[string]string{
+				"QUEUE_URL":  "amqp://rabbit",
+				"QUEUE_NAME": "queue",
+			}))
+		})
+	})
+
+	Describe("WriteEnvFiles", func() {
+		It("writes env files without overriding existing ones", func() {
+			platformDir := GinkgoT().TempDir()
+			Expect(os.MkdirAll(filepath.Join(platformDir, "env"), 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(platformDir, "env", "A"), []byte("explicit"), 0o644)).To(Succeed())
+
+			names, err := serviceenv.WriteEnvFiles(platformDir, map[string]string{"A": "mapped", "B": "mapped"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"B"}))
+
+			Expect(os.ReadFile(filepath.Join(platformDir, "env", "A"))).To(BeEquivalentTo("explicit"))
+			Expect(os.ReadFile(filepath.Join(platformDir, "env", "B"))).To(BeEquivalentTo("mapped"))
+		})
+	})
+
+	Describe("Setenv", func() {
+		It("sets env vars that are not set yet", func() {
+			GinkgoT().Setenv("SERVICEENV_A", "explicit")
+			GinkgoT().Setenv("SERVICEENV_B", "")
+			Expect(os.Unsetenv("SERVICEENV_B")).To(Succeed())
+
+			names, err := serviceenv.Setenv(map[string]string{"SERVICEENV_A": "mapped", "SERVICEENV_B": "mapped"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"SERVICEENV_B"}))
+			Expect(os.Getenv("SERVICEENV_A")).To(Equal("explicit"))
+			Expect(os.Getenv("SERVICEENV_B")).To(Equal("mapped"))
+		})
+	})
+})
@@ -0,0 +1,44 @@
+package staging
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DropletMetadata holds the settings the droplet was built with that the launcher needs to honour
+type DropletMetadata struct {
+	PlatformAPI string `toml:"platform-api,omitempty"`
+	ExecEnv     string `toml:"exec-env,omitempty"`
+}
+
+func DropletMetadataPath(layersDir string) string {
+	return filepath.Join(layersDir, "config", "droplet.toml")
+}
+
+func WriteDropletMetadata(layersDir string, md DropletMetadata) error {
+	path := DropletMetadataPath(layersDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return toml.NewEncoder(f).Encode(md)
+}
+
+// ReadDropletMetadata returns empty metadata for droplets built before the file was introduced
+func ReadDropletMetadata(layersDir string) (DropletMetadata, error) {
+	md := DropletMetadata{}
+	if _, err := toml.DecodeFile(DropletMetadataPath(layersDir), &md); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return md, err
+	}
+
+	return md, nil
+}
@@ -0,0 +1,41 @@
+package staging_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DropletMetadata", func() {
+	var layersDir string
+
+	BeforeEach(func() {
+		layersDir = GinkgoT().TempDir()
+	})
+
+	It("writes and reads the droplet metadata", func() {
+		Expect(staging.WriteDropletMetadata(layersDir, staging.DropletMetadata{PlatformAPI: "0.13", ExecEnv: "test"})).To(Succeed())
+		Expect(filepath.Join(layersDir, "config", "droplet.toml")).To(BeAnExistingFile())
+
+		md, err := staging.ReadDropletMetadata(layersDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(md).To(Equal(staging.DropletMetadata{PlatformAPI: "0.13", ExecEnv: "test"}))
+	})
+
+	It("returns empty metadata when the file does not exist", func() {
+		md, err := staging.ReadDropletMetadata(layersDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(md).To(Equal(staging.DropletMetadata{}))
+	})
+
+	It("fails for invalid files", func() {
+		Expect(os.MkdirAll(filepath.Join(layersDir, "config"), 0o755)).To(Succeed())
+		Expect(os.WriteFile(staging.DropletMetadataPath(layersDir), []byte("invalid = "), 0o644)).To(Succeed())
+
+		_, err := staging.ReadDropletMetadata(layersDir)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,15 +1,24 @@
 package staging
 
 import (
+	"slices"
 	"strings"
 
+	"github.com/buildpacks/lifecycle/api"
+	"github.com/buildpacks/lifecycle/launch"
 	"github.com/buildpacks/lifecycle/platform/files"
 )
 
-const LifecycleType = "cnb"
+const (
+	LifecycleType = "cnb"
+
+	// ExecEnvPlatformAPI is the first platform API at which processes are filtered by execution environment
+	ExecEnvPlatformAPI = "0.15"
+)
 
 type LifecycleMetadata struct {
-	Buildpacks []BuildpackMetadata `json:"buildpacks"`
+	Buildpacks []BuildpackMetadata   `json:"buildpacks"`
+	Target     *files.TargetMetadata `json:"target,omitempty"`
 }
 
 type BuildpackMetadata struct {
@@ -27,7 +36,8 @@ type StagingResult struct {
 	LifecycleType     string `json:"lifecycle_type"`
 }
 
-func StagingResultFromMetadata(buildMeta *files.BuildMetadata) *StagingResult {
+// StagingResultFromMetadata creates the staging result, only processes eligible for execEnv are reported
+func StagingResultFromMetadata(buildMeta *files.BuildMetadata, execEnv string, platformAPI *api.Version) *StagingResult {
 	result := &StagingResult{
 		LifecycleType: LifecycleType,
 		LifecycleMetadata: LifecycleMetadata{
@@ -45,8 +55,22 @@ func StagingResultFromMetadata(buildMeta *files.BuildMetadata) *StagingResult {
 	}
 
 	for _, process := range buildMeta.Processes {
+		if !ProcessEligibleForExecEnv(process, execEnv, platformAPI) {
+			continue
+		}
+
 		result.ProcessTypes[process.Type] = strings.Join(append(process.Command.Entries, process.Args...), " ")
 	}
 
 	return result
 }
+
+// ProcessEligibleForExecEnv mirrors the launcher, processes without exec-env or with "*" apply to every environment.
+// Like the launcher processes are only filtered from platform API 0.15 on.
+func ProcessEligibleForExecEnv(process launch.Process, execEnv string, platformAPI *api.Version) bool {
+	if platformAPI == nil || !platformAPI.AtLeast(ExecEnvPlatformAPI) {
+		return true
+	}
+
+	return execEnv == "" || len(process.ExecEnv) == 0 || slices.Contains(process.ExecEnv, "*") || slices.Contains(process.ExecEnv, execEnv)
+}
@@ -2,6 +2,7 @@ package staging_test
 
 import (
 	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
+	"github.com/buildpacks/lifecycle/api"
 	"github.com/buildpacks/lifecycle/buildpack"
 	"github.com/buildpacks/lifecycle/launch"
 	"github.com/buildpacks/lifecycle/platform/files"
@@ -10,10 +11,11 @@ import (
 )
 
 var _ = Describe("StagingResultFromMetadata", func() {
+	platformAPI := api.MustParse("0.15")
 
 	It("creates an empty StagingResult if metadata is empty", func() {
 		metadata := &files.BuildMetadata{}
-		result := staging.StagingResultFromMetadata(metadata)
+		result := staging.StagingResultFromMetadata(metadata, "production", platformAPI)
 
 		Expect(result.LifecycleType).To(Equal("cnb"))
 		Expect(result.Buildpacks).To(BeEmpty())
@@ -24,7 +26,7 @@ var _ = Describe("StagingResultFromMetadata", func() {
 		metadata := &files.BuildMetadata{
 			Buildpacks: []buildpack.GroupElement{{ID: "nodejs", Version: "1.0.0"}, {ID: "java", Version: "2.0.0"}},
 		}
-		result := staging.StagingResultFromMetadata(metadata)
+		result := staging.StagingResultFromMetadata(metadata, "production", platformAPI)
 
 		Expect(result.LifecycleType).To(Equal("cnb"))
 		Expect(result.Buildpacks).To(ContainElements(staging.BuildpackMetadata{ID: "nodejs", Name: "nodejs@1.0.0", Version: "1.0.0"},
@@ -38,9 +40,34 @@ var _ = Describe("StagingResultFromMetadata", func() {
 			Processes: []launch.Process{{Type: "web", Command: launch.NewRawCommand([]string{"start", "app"}), Args: []string{"--force"}},
 				{Type: "custom", Command: launch.NewRawCommand([]string{"start.sh"}), Args: []string{"--arg1"}}},
 		}
-		result := staging.StagingResultFromMetadata(metadata)
+		result := staging.StagingResultFromMetadata(metadata, "production", platformAPI)
 
 		Expect(result.LifecycleType).To(Equal("cnb"))
 		Expect(result.ProcessTypes).To(Equal(staging.ProcessTypes{"web": "start app --force", "custom": "start.sh --arg1"}))
 	})
+
+	It("only contains processes of the execution environment", func() {
+		metadata := &files.BuildMetadata{
+			Processes: []launch.Process{
+				{Type: "web", Command: launch.NewRawCommand([]string{"start"})},
+				{Type: "worker", Command: launch.NewRawCommand([]string{"work"}), ExecEnv: []string{"*"}},
+				{Type: "test", Command: launch.NewRawCommand([]string{"test"}), ExecEnv: []string{"test"}},
+				{Type: "debug", Command: launch.NewRawCommand([]string{"debug"}), ExecEnv: []string{"development", "test"}},
+			},
+		}
+
+		Expect(staging.StagingResultFromMetadata(metadata, "production", platformAPI).ProcessTypes).To(Equal(staging.ProcessTypes{"web": "start", "worker": "work"}))
+		Expect(staging.StagingResultFromMetadata(metadata, "test", platformAPI).ProcessTypes).To(Equal(staging.ProcessTypes{"web": "start", "worker": "work", "test": "test", "debug": "debug"}))
+	})
+
+	It("contains all processes below platform API 0.15", func() {
+		metadata := &files.BuildMetadata{
+			Processes: []launch.Process{
+				{Type: "web", Command: launch.NewRawCommand([]string{"start"})},
+				{Type: "test", Command: launch.NewRawCommand([]string{"test"}), ExecEnv: []string{"test"}},
+			},
+		}
+
+		Expect(staging.StagingResultFromMetadata(metadata, "production", api.MustParse("0.14")).ProcessTypes).To(Equal(staging.ProcessTypes{"web": "start", "test": "test"}))
+	})
 })
@@ -0,0 +1,87 @@
+package staging
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/buildpacks/lifecycle/platform/files"
+)
+
+const OSReleasePath = "/etc/os-release"
+
+// DetectTarget returns the target the droplet is built for, the distro is read from the os-release file when present
+func DetectTarget(osReleasePath string) (files.TargetMetadata, error) {
+	target := files.TargetMetadata{
+		OS:   "linux",
+		Arch: runtime.GOARCH,
+	}
+	if runtime.GOARCH == "arm64" {
+		target.ArchVariant = "v8"
+	}
+
+	f, err := os.Open(osReleasePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return target, nil
+		}
+
+		return target, err
+	}
+	defer f.Close()
+
+	distro := files.OSDistro{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "ID":
+			distro.Name = strings.Trim(value, `"' `)
+		case "VERSION_ID":
+			distro.Version = strings.Trim(value, `"' `)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return target, err
+	}
+
+	if distro.Name != "" || distro.Version != "" {
+		target.Distro = &distro
+	}
+
+	return target, nil
+}
+
+// ParseTarget parses a target in the format "os/arch[/variant][:distro@version]"
+func ParseTarget(value string) (files.TargetMetadata, error) {
+	target := files.TargetMetadata{}
+
+	platform, distro, hasDistro := strings.Cut(value, ":")
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return target, fmt.Errorf("invalid target %q, expected format os/arch[/variant][:distro@version]", value)
+	}
+
+	target.OS = parts[0]
+	target.Arch = parts[1]
+	if len(parts) == 3 {
+		target.ArchVariant = parts[2]
+	}
+
+	if hasDistro {
+		name, version, ok := strings.Cut(distro, "@")
+		if !ok || name == "" || version == "" {
+			return target, fmt.Errorf("invalid distro %q in target %q, expected format distro@version", distro, value)
+		}
+		target.Distro = &files.OSDistro{Name: name, Version: version}
+	}
+
+	return target, nil
+}
@@ -0,0 +1,67 @@
+package staging_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/staging"
+	"github.com/buildpacks/lifecycle/platform/files"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DetectTarget", func() {
+	var osRelease string
+
+	BeforeEach(func() {
+		osRelease = filepath.Join(GinkgoT().TempDir(), "os-release")
+	})
+
+	It("reads the distro from os-release", func() {
+		Expect(os.WriteFile(osRelease, []byte("NAME=\"Ubuntu\"\nID=ubuntu\nVERSION_ID=\"22.04\"\nVERSION_CODENAME=jammy\n"), 0o644)).To(Succeed())
+
+		target, err := staging.DetectTarget(osRelease)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.OS).To(Equal("linux"))
+		Expect(target.Arch).To(Equal(runtime.GOARCH))
+		Expect(target.Distro).To(Equal(&files.OSDistro{Name: "ubuntu", Version: "22.04"}))
+	})
+
+	It("omits the distro when os-release does not exist", func() {
+		target, err := staging.DetectTarget(osRelease)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.Distro).To(BeNil())
+		Expect(target.OS).To(Equal("linux"))
+	})
+})
+
+var _ = Describe("ParseTarget", func() {
+	It("parses os and arch", func() {
+		target, err := staging.ParseTarget("linux/amd64")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(files.TargetMetadata{OS: "linux", Arch: "amd64"}))
+	})
+
+	It("parses variant and distro", func() {
+		target, err := staging.ParseTarget("linux/arm64/v8:ubuntu@24.04")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(files.TargetMetadata{
+			OS:          "linux",
+			Arch:        "arm64",
+			ArchVariant: "v8",
+			Distro:      &files.OSDistro{Name: "ubuntu", Version: "24.04"},
+		}))
+	})
+
+	DescribeTable("rejects invalid targets",
+		func(value string) {
+			_, err := staging.ParseTarget(value)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("missing arch", "linux"),
+		Entry("empty os", "/amd64"),
+		Entry("too many parts", "linux/arm64/v8/extra"),
+		Entry("distro without version", "linux/amd64:ubuntu"),
+	)
+})
@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"github.com/docker/go-units"
+)
+
+const (
+	// LargeDownloadSize is the size from which the progress of a download is logged
+	LargeDownloadSize = 20 * 1024 * 1024
+	// MaxRetryDelay is the delay up to which Backoff grows
+	MaxRetryDelay    = 2 * time.Minute
+	progressInterval = 10 * time.Second
+)
+
+type roundTripper struct {
+	inner    http.RoundTripper
+	timeout  time.Duration
+	attempts int
+	delay    time.Duration
+	logger   *log.Logger
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := max(rt.attempts, 1)
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 1; ; attempt++ {
+		res, err := rt.roundTrip(req)
+		if attempt >= attempts || !replayable || !retryable(req, res, err) {
+			return res, err
+		}
+
+		reason := "network error"
+		if err == nil {
+			reason = res.Status
+			res.Body.Close()
+		}
+		delay := Backoff(rt.delay, attempt)
+		rt.logger.Warnf("Request to %s failed (%s), retrying in %s (attempt %d/%d)", req.URL.Host, reason, delay.Round(time.Millisecond), attempt, attempts)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// roundTrip sends a single request, the timeout covers reading the response body
+func (rt *roundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if rt.timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), rt.timeout)
+		req = req.WithContext(ctx)
+	}
+
+	res, err := rt.inner.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	body := res.Body
+	if res.ContentLength >= LargeDownloadSize && req.Method == http.MethodGet && rt.logger != nil {
+		body = newProgressReader(body, res.ContentLength, req.URL.Host+req.URL.Path, rt.logger)
+	}
+	res.Body = &cancelBody{ReadCloser: body, cancel: cancel}
+	return res, nil
+}
+
+// retryable reports whether a failed idempotent request should be sent again
+func retryable(req *http.Request, res *http.Response, err error) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && req.GetBody == nil {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+	}
+
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// Backoff doubles the delay with every attempt up to MaxRetryDelay, or delay if it is larger, and adds jitter
+func Backoff(delay time.Duration, attempt int) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+
+	d := max(delay, MaxRetryDelay)
+	if shift := attempt - 1; shift < 63 && delay <= d>>shift {
+		d = delay << shift
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+type progressReader struct {
+	io.ReadCloser
+	size    int64
+	read    int64
+	name    string
+	started time.Time
+	logged  time.Time
+	done    bool
+	logger  *log.Logger
+}
+
+func newProgressReader(body io.ReadCloser, size int64, name string, logger *log.Logger) *progressReader {
+	logger.Infof("Downloading %s (%s)", name, units.HumanSize(float64(size)))
+	now := time.Now()
+	return &progressReader{ReadCloser: body, size: size, name: name, started: now, logged: now, logger: logger}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+
+	switch {
+	case r.done:
+	case errors.Is(err, io.EOF) || r.read >= r.size:
+		r.logger.Infof("Downloaded %s (%s) in %s", r.name, units.HumanSize(float64(r.read)), time.Since(r.started).Round(time.Second))
+		r.done = true
+	case time.Since(r.logged) >= progressInterval:
+		r.logger.Infof("Downloading %s: %s of %s (%d%%)", r.name, units.HumanSize(float64(r.read)), units.HumanSize(float64(r.size)), r.read*100/r.size)
+		r.logged = time.Now()
+	}
+
+	return n, err
+}
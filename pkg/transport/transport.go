@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+)
+
+// Options configure the transport used for buildpack downloads and registry requests
+type Options struct {
+	// CACerts is a PEM bundle or a directory of .crt and .pem files trusted in addition to the system certs
+	CACerts string
+	// ClientCert and ClientKey are the PEM files of the certificate presented to servers asking for one
+	ClientCert string
+	ClientKey  string
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// ConnectTimeout limits dialing and the TLS handshake, Timeout a whole request including its body
+	ConnectTimeout time.Duration
+	Timeout        time.Duration
+	Attempts       int
+	RetryDelay     time.Duration
+}
+
+// New returns a transport with the given options retrying transient failures and logging the progress of
+// large downloads
+func New(options Options, logger *log.Logger) (http.RoundTripper, error) {
+	rootCAs, err := certPool(options.CACerts)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := options.proxy()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	if options.ClientCert != "" && options.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't read client cert: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	inner := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   options.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   options.ConnectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &roundTripper{
+		inner:    inner,
+		timeout:  options.Timeout,
+		attempts: options.Attempts,
+		delay:    options.RetryDelay,
+		logger:   logger,
+	}, nil
+}
+
+func certPool(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if path == "" {
+		return pool, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read CA certs: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA certs: %w", err)
+		}
+		files = nil
+		for _, entry := range entries {
+			if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".crt" || ext == ".pem") {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA cert: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+
+	return pool, nil
+}
+
+// proxy uses the proxy options instead of HTTP_PROXY, HTTPS_PROXY and NO_PROXY, the environment is used for the
+// options that are not set
+func (o Options) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if o.HTTPProxy == "" && o.HTTPSProxy == "" && o.NoProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	noProxy := cmp.Or(o.NoProxy, getenv("NO_PROXY", "no_proxy"))
+	proxies := map[string]*url.URL{}
+	for scheme, value := range map[string]string{
+		"http":  cmp.Or(o.HTTPProxy, getenv("HTTP_PROXY", "http_proxy")),
+		"https": cmp.Or(o.HTTPSProxy, getenv("HTTPS_PROXY", "https_proxy")),
+	} {
+		if value == "" {
+			continue
+		}
+		// like net/http proxies without scheme are HTTP proxies
+		if !strings.Contains(value, "://") {
+			value = "http://" + value
+		}
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid %s proxy %q", scheme, value)
+		}
+		proxies[scheme] = proxyURL
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxies[req.URL.Scheme], nil
+	}, nil
+}
+
+// getenv returns the value of the first of names that is set
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// bypassProxy matches a host against a NO_PROXY list of hosts, domains, IPs and CIDRs
+func bypassProxy(host string, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		domain := strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,202 @@
+package transport_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/log"
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/transport"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transport", func() {
+	var (
+		options  transport.Options
+		requests atomic.Int32
+		handler  http.HandlerFunc
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests.Store(0)
+		options = transport.Options{ConnectTimeout: time.Second, Attempts: 3, RetryDelay: time.Millisecond}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			handler(w, r)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(url string) (*http.Response, error) {
+		rt, err := transport.New(options, log.NewLogger())
+		Expect(err).NotTo(HaveOccurred())
+		return (&http.Client{Transport: rt}).Get(url)
+	}
+
+	It("retries transient failures", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			if requests.Load() < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("buildpack"))
+		}
+
+		res, err := get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(requests.Load()).To(Equal(int32(3)))
+	})
+
+	It("returns the last response after all attempts", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+
+		res, err := get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusBadGateway))
+		Expect(requests.Load()).To(Equal(int32(3)))
+	})
+
+	It("does not retry client errors", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+		res, err := get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(requests.Load()).To(Equal(int32(1)))
+	})
+
+	It("times out slow requests", func() {
+		options.Timeout = 50 * time.Millisecond
+		options.Attempts = 2
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+
+		_, err := get(server.URL)
+		Expect(err).To(MatchError(ContainSubstring("context deadline exceeded")))
+		Expect(requests.Load()).To(Equal(int32(2)))
+	})
+
+	It("reads large downloads", func() {
+		content := bytes.Repeat([]byte("x"), transport.LargeDownloadSize)
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(content)
+		}
+
+		res, err := get(server.URL)
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(body).To(HaveLen(len(content)))
+	})
+
+	Context("with a private CA", func() {
+		var tlsServer *httptest.Server
+
+		BeforeEach(func() {
+			tlsServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("buildpack"))
+			}))
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		It("fails without the CA", func() {
+			_, err := get(tlsServer.URL)
+			Expect(err).To(MatchError(ContainSubstring("certificate")))
+		})
+
+		It("trusts the CA certs in a directory", func() {
+			certDir := GinkgoT().TempDir()
+			cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+			Expect(os.WriteFile(filepath.Join(certDir, "ca.crt"), cert, 0o644)).To(Succeed())
+			options.CACerts = certDir
+
+			res, err := get(tlsServer.URL)
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("fails for a file without certs", func() {
+			options.CACerts = filepath.Join(GinkgoT().TempDir(), "ca.pem")
+			Expect(os.WriteFile(options.CACerts, []byte("invalid"), 0o644)).To(Succeed())
+
+			_, err := transport.New(options, log.NewLogger())
+			Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+		})
+	})
+
+	Context("with a proxy", func() {
+		It("sends requests through the proxy", func() {
+			var proxiedHost string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				proxiedHost = r.Host
+			}
+			options.HTTPProxy = server.URL
+
+			res, err := get("http://buildpacks.test/bp.tgz")
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(proxiedHost).To(Equal("buildpacks.test"))
+		})
+
+		It("bypasses the proxy for no proxy hosts", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {}
+			options.HTTPProxy = "http://127.0.0.1:1"
+			options.NoProxy = "example.com, 127.0.0.0/8"
+
+			res, err := get(server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("keeps the proxy of the environment when only no proxy is set", func() {
+			var proxiedHost string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				proxiedHost = r.Host
+			}
+			GinkgoT().Setenv("HTTP_PROXY", server.URL)
+			options.NoProxy = "example.com"
+
+			res, err := get("http://buildpacks.test/bp.tgz")
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(proxiedHost).To(Equal("buildpacks.test"))
+		})
+
+		It("fails for an invalid proxy", func() {
+			options.HTTPSProxy = "://proxy"
+
+			_, err := transport.New(options, log.NewLogger())
+			Expect(err).To(MatchError(`invalid https proxy "://proxy"`))
+		})
+	})
+})
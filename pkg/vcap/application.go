@@ -0,0 +1,58 @@
+package vcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type Application struct {
+	ApplicationID      string   `json:"application_id"`
+	ApplicationName    string   `json:"application_name"`
+	ApplicationURIs    []string `json:"application_uris"`
+	ApplicationVersion string   `json:"application_version"`
+	CFAPI              string   `json:"cf_api"`
+	Limits             Limits   `json:"limits"`
+	Name               string   `json:"name"`
+	OrganizationID     string   `json:"organization_id"`
+	OrganizationName   string   `json:"organization_name"`
+	SpaceID            string   `json:"space_id"`
+	SpaceName          string   `json:"space_name"`
+	URIs               []string `json:"uris"`
+	Version            string   `json:"version"`
+	InstanceID         string   `json:"instance_id"`
+	InstanceIndex      *int     `json:"instance_index"`
+	Host               string   `json:"host"`
+	Port               int      `json:"port"`
+	ProcessID          string   `json:"process_id"`
+	ProcessType        string   `json:"process_type"`
+}
+
+type Limits struct {
+	Disk int `json:"disk"`
+	FDs  int `json:"fds"`
+	Mem  int `json:"mem"`
+}
+
+// ApplicationFromEnv parses VCAP_APPLICATION
+func ApplicationFromEnv(mode Mode) (Application, error) {
+	return ParseApplication(os.Getenv(ApplicationEnv), mode)
+}
+
+// ParseApplication parses the content of VCAP_APPLICATION, an empty string results in an empty application
+func ParseApplication(content string, mode Mode) (Application, error) {
+	app := Application{}
+	if content == "" {
+		return app, nil
+	}
+
+	if err := json.Unmarshal([]byte(content), &app); err != nil {
+		// fields with unexpected types are left empty
+		if mode == Lenient {
+			return app, nil
+		}
+		return Application{}, fmt.Errorf("failed to parse %s: %w", ApplicationEnv, err)
+	}
+
+	return app, nil
+}
@@ -0,0 +1,45 @@
+package vcap_test
+
+import (
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Application", func() {
+	It("parses VCAP_APPLICATION", func() {
+		app, err := vcap.ParseApplication(`{
+			"application_id": "app-guid",
+			"application_name": "my-app",
+			"application_uris": ["my-app.example.com"],
+			"limits": {"disk": 1024, "fds": 16384, "mem": 256},
+			"space_name": "dev",
+			"organization_name": "org",
+			"instance_index": 0
+		}`, vcap.Strict)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.ApplicationID).To(Equal("app-guid"))
+		Expect(app.ApplicationName).To(Equal("my-app"))
+		Expect(app.ApplicationURIs).To(Equal([]string{"my-app.example.com"}))
+		Expect(app.Limits).To(Equal(vcap.Limits{Disk: 1024, FDs: 16384, Mem: 256}))
+		Expect(app.SpaceName).To(Equal("dev"))
+		Expect(app.InstanceIndex).To(HaveValue(Equal(0)))
+	})
+
+	It("returns an empty application for empty content", func() {
+		app, err := vcap.ParseApplication("", vcap.Strict)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app).To(Equal(vcap.Application{}))
+	})
+
+	It("fails for malformed content in strict mode", func() {
+		_, err := vcap.ParseApplication(`{"limits": "none"}`, vcap.Strict)
+		Expect(err).To(MatchError(ContainSubstring("failed to parse VCAP_APPLICATION")))
+	})
+
+	It("keeps the valid fields in lenient mode", func() {
+		app, err := vcap.ParseApplication(`{"application_name": "my-app", "limits": "none"}`, vcap.Lenient)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(app.ApplicationName).To(Equal("my-app"))
+	})
+})
@@ -0,0 +1,158 @@
+package vcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+const (
+	ServicesEnv    = "VCAP_SERVICES"
+	ApplicationEnv = "VCAP_APPLICATION"
+
+	credhubRefKey = "credhub-ref"
+)
+
+// Mode controls how malformed content is handled
+type Mode int
+
+const (
+	// Strict fails on any malformed content
+	Strict Mode = iota
+	// Lenient skips malformed service instances and treats unparsable content as empty
+	Lenient
+)
+
+type Services map[string][]Service
+
+type Service struct {
+	Name           string         `json:"name"`
+	Label          string         `json:"label"`
+	Tags           []string       `json:"tags"`
+	Plan           string         `json:"plan"`
+	Provider       string         `json:"provider"`
+	InstanceGUID   string         `json:"instance_guid"`
+	InstanceName   string         `json:"instance_name"`
+	BindingGUID    string         `json:"binding_guid"`
+	BindingName    string         `json:"binding_name"`
+	SyslogDrainURL string         `json:"syslog_drain_url"`
+	Credentials    map[string]any `json:"credentials"`
+	VolumeMounts   []VolumeMount  `json:"volume_mounts"`
+}
+
+type VolumeMount struct {
+	ContainerDir string `json:"container_dir"`
+	Mode         string `json:"mode"`
+	DeviceType   string `json:"device_type"`
+}
+
+// ServicesFromEnv parses VCAP_SERVICES
+func ServicesFromEnv(mode Mode) (Services, error) {
+	return ParseServices(os.Getenv(ServicesEnv), mode)
+}
+
+// ParseServices parses the content of VCAP_SERVICES, an empty string results in no services. Services without a
+// label get the label they are listed under.
+func ParseServices(content string, mode Mode) (Services, error) {
+	services := Services{}
+	if content == "" {
+		return services, nil
+	}
+
+	raw := map[string][]json.RawMessage{}
+	if err := json.Unmarshal([]byte(content), &raw); err != nil {
+		if mode == Lenient {
+			return services, nil
+		}
+		return nil, fmt.Errorf("failed to parse %s: %w", ServicesEnv, err)
+	}
+
+	for label, instances := range raw {
+		for i, instance := range instances {
+			svc := Service{}
+			if err := json.Unmarshal(instance, &svc); err != nil {
+				if mode == Lenient {
+					continue
+				}
+				return nil, fmt.Errorf("failed to parse %s service %q at index %d: %w", ServicesEnv, label, i, err)
+			}
+
+			if svc.Label == "" {
+				svc.Label = label
+			}
+			services[label] = append(services[label], svc)
+		}
+	}
+
+	return services, nil
+}
+
+// All returns all service instances ordered by label, keeping the order within a label
+func (s Services) All() []Service {
+	labels := make([]string, 0, len(s))
+	for label := range s {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	all := []Service{}
+	for _, label := range labels {
+		all = append(all, s[label]...)
+	}
+
+	return all
+}
+
+// ByName returns the service instance with the given name
+func (s Services) ByName(name string) (Service, bool) {
+	for _, svc := range s.All() {
+		if svc.Name == name {
+			return svc, true
+		}
+	}
+
+	return Service{}, false
+}
+
+// ByLabel returns the service instances with the given label
+func (s Services) ByLabel(label string) []Service {
+	return s.filter(func(svc Service) bool {
+		return svc.Label == label
+	})
+}
+
+// ByTag returns the service instances tagged with tag
+func (s Services) ByTag(tag string) []Service {
+	return s.filter(func(svc Service) bool {
+		return svc.HasTag(tag)
+	})
+}
+
+// HasCredHubRefs reports whether any service instance has credentials stored in CredHub
+func (s Services) HasCredHubRefs() bool {
+	return len(s.filter(Service.HasCredHubRef)) > 0
+}
+
+func (s Services) filter(fn func(Service) bool) []Service {
+	return slices.DeleteFunc(s.All(), func(svc Service) bool {
+		return !fn(svc)
+	})
+}
+
+func (s Service) HasTag(tag string) bool {
+	return slices.Contains(s.Tags, tag)
+}
+
+// HasCredHubRef reports whether the credentials of the service instance are stored in CredHub
+func (s Service) HasCredHubRef() bool {
+	_, ok := s.Credentials[credhubRefKey]
+	return ok
+}
+
+// Credential returns the credential with the given key if it is a string
+func (s Service) Credential(key string) (string, bool) {
+	value, ok := s.Credentials[key].(string)
+	return value, ok
+}
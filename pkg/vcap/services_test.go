@@ -0,0 +1,141 @@
+package vcap_test
+
+import (
+	"code.cloudfoundry.org/cnbapplifecycle/pkg/vcap"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const servicesJSON = `{
+	"postgresql": [{
+		"name": "db",
+		"label": "postgresql",
+		"tags": ["relational", "postgres"],
+		"plan": "small",
+		"instance_guid": "instance-guid",
+		"binding_guid": "binding-guid",
+		"credentials": {"uri": "postgres://host/db", "port": 5432}
+	}],
+	"user-provided": [{
+		"name": "secrets",
+		"tags": [],
+		"credentials": {"credhub-ref": "/c/secrets"}
+	}],
+	"nfs": [{
+		"name": "files",
+		"label": "nfs",
+		"credentials": {},
+		"volume_mounts": [{"container_dir": "/var/vcap/data/files", "mode": "rw", "device_type": "shared"}]
+	}]
+}`
+
+var _ = Describe("Services", func() {
+	Describe("ParseServices", func() {
+		It("parses all fields", func() {
+			services, err := vcap.ParseServices(servicesJSON, vcap.Strict)
+			Expect(err).NotTo(HaveOccurred())
+
+			db, ok := services.ByName("db")
+			Expect(ok).To(BeTrue())
+			Expect(db.Plan).To(Equal("small"))
+			Expect(db.InstanceGUID).To(Equal("instance-guid"))
+			Expect(db.BindingGUID).To(Equal("binding-guid"))
+			Expect(db.Credentials).To(HaveKeyWithValue("port", BeNumerically("==", 5432)))
+
+			files, ok := services.ByName("files")
+			Expect(ok).To(BeTrue())
+			Expect(files.VolumeMounts).To(Equal([]vcap.VolumeMount{{ContainerDir: "/var/vcap/data/files", Mode: "rw", DeviceType: "shared"}}))
+		})
+
+		It("uses the key as label for services without one", func() {
+			services, err := vcap.ParseServices(servicesJSON, vcap.Strict)
+			Expect(err).NotTo(HaveOccurred())
+
+			secrets, ok := services.ByName("secrets")
+			Expect(ok).To(BeTrue())
+			Expect(secrets.Label).To(Equal("user-provided"))
+		})
+
+		It("returns no services for empty content", func() {
+			services, err := vcap.ParseServices("", vcap.Strict)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(services).To(BeEmpty())
+		})
+
+		Context("strict", func() {
+			It("fails for invalid JSON", func() {
+				_, err := vcap.ParseServices("{", vcap.Strict)
+				Expect(err).To(MatchError(ContainSubstring("failed to parse VCAP_SERVICES")))
+			})
+
+			It("fails for malformed service instances", func() {
+				_, err := vcap.ParseServices(`{"redis": [{"name": 1}]}`, vcap.Strict)
+				Expect(err).To(MatchError(ContainSubstring(`service "redis" at index 0`)))
+			})
+		})
+
+		Context("lenient", func() {
+			It("returns no services for invalid JSON", func() {
+				services, err := vcap.ParseServices("{", vcap.Lenient)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(services).To(BeEmpty())
+			})
+
+			It("skips malformed service instances", func() {
+				services, err := vcap.ParseServices(`{"redis": [{"name": 1}, {"name": "cache"}]}`, vcap.Lenient)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(services.All()).To(HaveLen(1))
+				Expect(services.All()[0].Name).To(Equal("cache"))
+			})
+		})
+	})
+
+	Describe("lookups", func() {
+		var services vcap.Services
+
+		BeforeEach(func() {
+			var err error
+			services, err = vcap.ParseServices(servicesJSON, vcap.Strict)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns all services ordered by label", func() {
+			names := []string{}
+			for _, svc := range services.All() {
+				names = append(names, svc.Name)
+			}
+			Expect(names).To(Equal([]string{"files", "db", "secrets"}))
+		})
+
+		It("finds services by name", func() {
+			_, ok := services.ByName("missing")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("finds services by label", func() {
+			Expect(services.ByLabel("nfs")).To(HaveLen(1))
+			Expect(services.ByLabel("missing")).To(BeEmpty())
+		})
+
+		It("finds services by tag", func() {
+			Expect(services.ByTag("relational")).To(HaveLen(1))
+			Expect(services.ByTag("relational")[0].Name).To(Equal("db"))
+			Expect(services.ByTag("missing")).To(BeEmpty())
+		})
+
+		It("detects credhub references", func() {
+			Expect(services.HasCredHubRefs()).To(BeTrue())
+			Expect(vcap.Services{"redis": {{Credentials: map[string]any{"password": "credhub-ref"}}}}.HasCredHubRefs()).To(BeFalse())
+		})
+
+		It("returns string credentials", func() {
+			db, _ := services.ByName("db")
+			uri, ok := db.Credential("uri")
+			Expect(ok).To(BeTrue())
+			Expect(uri).To(Equal("postgres://host/db"))
+
+			_, ok = db.Credential("port")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
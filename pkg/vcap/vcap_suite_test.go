@@ -0,0 +1,13 @@
+package vcap_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestVcap(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vcap Suite")
+}